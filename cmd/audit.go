@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+	"golang.org/x/crypto/openpgp"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "report who can read each secret",
+	Long: `Report who can read each secret in the vault, and flag secrets whose
+readers differ from the current members of their group. Only the
+encrypted session keys at the start of each secret are read, so no
+passphrase is needed.
+
+The matrix marks each reader with x if they are a member of the secret's
+group, S (stale) if they can read the secret but are no longer a member,
+and M (missing) if they are a member but can't read it yet. Stale and
+missing readers go away when the secret is recrypted.
+
+Example:
+
+$ conspire audit
+
+Readers:
+   1 4ABEABCDEFCC123B Sherlock Holmes <sherlock.holmes@bakerstreet.co.uk>
+   2 4ABEABCDEFCC123C Hercule Poirot <hercule.poirot@whitehaven.co.uk>
+
+ Secret                         Group                 1  2 Status
+------------------------------ -------------------- -- -- --------------
+prod/db/password               ops                   x  S stale
+prod/api/token                 ops                   x  . ok
+
+`,
+	Run: auditVault,
+}
+
+var auditJSON = false
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().BoolVarP(&auditJSON, "json", "j", false, "JSON output")
+}
+
+// auditStatus summarises the access to a secret in a word or two.
+func auditStatus(acc *vault.Access) string {
+	switch {
+	case acc.Err != nil:
+		return "error"
+	case acc.Group == "":
+		return "unknown group"
+	case len(acc.Stale) > 0 && len(acc.Missing) > 0:
+		return "stale,missing"
+	case len(acc.Stale) > 0:
+		return "stale"
+	case len(acc.Missing) > 0:
+		return "missing"
+	}
+	return "ok"
+}
+
+// keyIdentity returns the first identity of the key, or "" if it isn't
+// known.
+func keyIdentity(keys map[uint64]*openpgp.Entity, id uint64) string {
+	e := keys[id]
+	if e == nil {
+		return ""
+	}
+	for _, ident := range e.Identities {
+		if ident.SelfSignature != nil && ident.SelfSignature.IsPrimaryId != nil && *ident.SelfSignature.IsPrimaryId {
+			return ident.Name
+		}
+	}
+	for _, ident := range e.Identities {
+		return ident.Name
+	}
+	return ""
+}
+
+func keyIds(ids []uint64) []string {
+	s := []string{}
+	for _, id := range ids {
+		s = append(s, fmt.Sprintf("%016X", id))
+	}
+	return s
+}
+
+func hasKeyId(ids []uint64, id uint64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+type auditReader struct {
+	KeyId    string `json:"keyid"`
+	Identity string `json:"identity,omitempty"`
+}
+
+type auditSecret struct {
+	Name    string   `json:"name"`
+	Group   string   `json:"group"`
+	Status  string   `json:"status"`
+	Readers []string `json:"readers"`
+	Stale   []string `json:"stale"`
+	Missing []string `json:"missing"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type auditReport struct {
+	Readers []auditReader `json:"readers"`
+	Secrets []auditSecret `json:"secrets"`
+}
+
+func auditVault(cmd *cobra.Command, args []string) {
+
+	audit, err := openVault().Audit()
+	if err != nil {
+		exitf(err, "Couldn't audit vault %v", VaultDir)
+	}
+
+	// every key that can read or should read some secret
+	var readers []uint64
+	for _, acc := range audit.Secrets {
+		for _, ids := range [][]uint64{acc.Readers, acc.Missing} {
+			for _, id := range ids {
+				if !hasKeyId(readers, id) {
+					readers = append(readers, id)
+				}
+			}
+		}
+	}
+
+	if auditJSON {
+		report := auditReport{Readers: []auditReader{}, Secrets: []auditSecret{}}
+		for _, id := range readers {
+			report.Readers = append(report.Readers, auditReader{fmt.Sprintf("%016X", id), keyIdentity(audit.Keys, id)})
+		}
+		for _, acc := range audit.Secrets {
+			s := auditSecret{
+				Name:    acc.Name,
+				Group:   acc.Group,
+				Status:  auditStatus(acc),
+				Readers: keyIds(acc.Readers),
+				Stale:   keyIds(acc.Stale),
+				Missing: keyIds(acc.Missing),
+			}
+			if acc.Err != nil {
+				s.Error = acc.Err.Error()
+			}
+			report.Secrets = append(report.Secrets, s)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(report); err != nil {
+			exitf(err, "Couldn't write audit report")
+		}
+		return
+	}
+
+	if Terse {
+		// terse give a minimal, parseable format
+		for _, acc := range audit.Secrets {
+			fmt.Printf("%s;%s;%s;%s;%s;%s\n", acc.Name, acc.Group, auditStatus(acc),
+				strings.Join(keyIds(acc.Readers), ","), strings.Join(keyIds(acc.Stale), ","), strings.Join(keyIds(acc.Missing), ","))
+		}
+		return
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Readers:\n")
+	for i, id := range readers {
+		fmt.Printf("%4d %016X %s\n", i+1, id, keyIdentity(audit.Keys, id))
+	}
+	fmt.Printf("\n")
+
+	fmt.Printf("%-30s %-20s", "Secret", "Group")
+	for i := range readers {
+		fmt.Printf(" %2d", i+1)
+	}
+	fmt.Printf(" Status\n")
+	fmt.Printf("------------------------------ --------------------")
+	for range readers {
+		fmt.Printf(" --")
+	}
+	fmt.Printf(" --------------\n")
+
+	for _, acc := range audit.Secrets {
+		fmt.Printf("%-30s %-20s", acc.Name, acc.Group)
+		for _, id := range readers {
+			mark := "."
+			switch {
+			case hasKeyId(acc.Stale, id):
+				mark = "S"
+			case hasKeyId(acc.Missing, id):
+				mark = "M"
+			case hasKeyId(acc.Readers, id):
+				mark = "x"
+			}
+			fmt.Printf(" %2s", mark)
+		}
+		fmt.Printf(" %s\n", auditStatus(acc))
+		if acc.Err != nil {
+			fmt.Printf("  %v\n", acc.Err)
+		}
+	}
+
+	fmt.Printf("\n")
+
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var credentialProcessCmd = &cobra.Command{
+	Use:   "credential-process <secret>",
+	Short: "print a structured secret as credentials for cloud tools",
+	Long: `Print the fields of a structured secret as the JSON credentials document a
+cloud tool asks an external process for, so tools can read team-shared
+keys straight from the vault.
+
+--format aws, the default, prints the document for the credential_process
+setting of the AWS CLI and SDKs, from the fields AccessKeyId,
+SecretAccessKey and, if present, SessionToken and Expiration. The AWS
+names aws_access_key_id, aws_secret_access_key and aws_session_token work
+too. In ~/.aws/config:
+
+[profile team]
+credential_process = conspire credential-process aws/team
+
+--format kubectl prints a kubectl ExecCredential from the token field, or
+the password, and expirationTimestamp, clientCertificateData and
+clientKeyData if present.
+
+--format json prints all the fields as one JSON object. Any other format is
+a Go text/template, where {{ field "name" }} is a field of the secret and
+json quotes a value as a JSON string:
+
+$ conspire credential-process ci/registry --format '{"token": {{ field "token" | json }}}'
+
+A missing field fails with exit status 15.`,
+	Args: cobra.ExactArgs(1),
+	Run:  credentialProcess,
+}
+
+var formatFlag = "aws"
+
+func init() {
+	RootCmd.AddCommand(credentialProcessCmd)
+	credentialProcessCmd.Flags().StringVarP(&formatFlag, "format", "f", formatFlag, "aws, kubectl, json, or a template")
+}
+
+// awsCredentials is the output of an AWS credential_process.
+type awsCredentials struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string `json:",omitempty"`
+	Expiration      string `json:",omitempty"`
+}
+
+// execCredential is the kubectl ExecCredential object.
+type execCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	Token                 string `json:"token,omitempty"`
+	ExpirationTimestamp   string `json:"expirationTimestamp,omitempty"`
+	ClientCertificateData string `json:"clientCertificateData,omitempty"`
+	ClientKeyData         string `json:"clientKeyData,omitempty"`
+}
+
+// lookupField returns the first of the named fields the record has, or
+// the error for the first name if it has none of them.
+func lookupField(r *vault.Record, names ...string) (string, error) {
+	var first error
+	for _, name := range names {
+		value, err := r.Field(name)
+		if err == nil {
+			return value, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return "", first
+}
+
+func credentialProcess(cmd *cobra.Command, args []string) {
+
+	name := args[0]
+
+	// parse a template before asking for a passphrase
+	var t *template.Template
+	var r *vault.Record
+	switch formatFlag {
+	case "aws", "kubectl", "json":
+	default:
+		funcs := template.FuncMap{
+			"field": func(field string) (string, error) { return r.Field(field) },
+			"json": func(s string) (string, error) {
+				out, err := json.Marshal(s)
+				return string(out), err
+			},
+		}
+		var err error
+		t, err = template.New("format").Funcs(funcs).Parse(formatFlag)
+		if err != nil {
+			usagef("Couldn't parse format\n%v", err)
+		}
+	}
+
+	secret, err := openVault().ReadSecret(name)
+	if err != nil {
+		exitf(err, "Couldn't read secret %v", name)
+	}
+	r, err = secret.Record()
+	if err != nil {
+		exitf(err, "Secret %v isn't a structured secret", name)
+	}
+
+	var out interface{}
+	switch formatFlag {
+	case "aws":
+		creds := awsCredentials{Version: 1}
+		if creds.AccessKeyId, err = lookupField(r, "AccessKeyId", "aws_access_key_id"); err != nil {
+			exitf(err, "Secret %v has no access key id", name)
+		}
+		if creds.SecretAccessKey, err = lookupField(r, "SecretAccessKey", "aws_secret_access_key"); err != nil {
+			exitf(err, "Secret %v has no secret access key", name)
+		}
+		creds.SessionToken, _ = lookupField(r, "SessionToken", "aws_session_token")
+		creds.Expiration, _ = lookupField(r, "Expiration")
+		out = creds
+
+	case "kubectl":
+		creds := execCredential{APIVersion: execCredentialVersion(), Kind: "ExecCredential"}
+		creds.Status.Token, _ = lookupField(r, "token", vault.PasswordField)
+		creds.Status.ExpirationTimestamp, _ = lookupField(r, "expirationTimestamp")
+		creds.Status.ClientCertificateData, _ = lookupField(r, "clientCertificateData")
+		creds.Status.ClientKeyData, _ = lookupField(r, "clientKeyData")
+		if creds.Status.Token == "" && creds.Status.ClientCertificateData == "" {
+			exitf(&vault.Error{Op: "read field", Name: name + ":token", Kind: vault.ErrNoField}, "Secret %v has no token or client certificate", name)
+		}
+		out = creds
+
+	case "json":
+		// keep the fields in order
+		buf := new(bytes.Buffer)
+		buf.WriteString("{")
+		for i, f := range r.Fields() {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			k, _ := json.Marshal(f.Key)
+			v, _ := json.Marshal(f.Value)
+			buf.Write(k)
+			buf.WriteString(":")
+			buf.Write(v)
+		}
+		buf.WriteString("}")
+		out = json.RawMessage(buf.Bytes())
+
+	default:
+		buf := new(bytes.Buffer)
+		if err := t.Execute(buf, nil); err != nil {
+			exitf(err, "Couldn't format secret %v", name)
+		}
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			exitf(err, "Couldn't write data to StdOut")
+		}
+		if !strings.HasSuffix(buf.String(), "\n") {
+			os.Stdout.WriteString("\n")
+		}
+		return
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		exitf(err, "Couldn't write data to StdOut")
+	}
+}
+
+// execCredentialVersion returns the ExecCredential version kubectl asks
+// for in KUBERNETES_EXEC_INFO, or v1.
+func execCredentialVersion() string {
+	var info struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	if json.Unmarshal([]byte(os.Getenv("KUBERNETES_EXEC_INFO")), &info) == nil && info.APIVersion != "" {
+		return info.APIVersion
+	}
+	return "client.authentication.k8s.io/v1"
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var dockerCredentialCmd = &cobra.Command{
+	Use:   "docker-credential <get|store|erase|list>",
+	Short: "act as a docker credential helper",
+	Long: `Act as a docker credential helper, keeping registry credentials in the
+vault rather than in ~/.docker/config.json. Docker runs helpers as
+docker-credential-<name>, so link conspire under that name and tell
+docker to use it:
+
+$ ln -s $(which conspire) /usr/local/bin/docker-credential-conspire
+$ echo '{ "credsStore": "conspire" }' > ~/.docker/config.json
+
+Set CONSPIRACY_VAULT to the vault for docker to use. Credentials for
+registry.example.com:5000 are kept in the structured secret
+docker/registry.example.com_5000, with the secret on the first line and the
+username and server URL in "username: " and "url: " lines. A secret of
+more than one line, such as a JSON key, is kept in a JSON secret with
+password, username and url fields instead. Bind the docker directory of the vault to a group to share them with it:
+
+$ conspire group bind ops docker
+
+Listing the credentials decrypts each of them.`,
+	Args: cobra.ExactArgs(1),
+	Run:  dockerCredential,
+}
+
+// dockerHelperName is the name docker runs the helper by.
+const dockerHelperName = "docker-credential-conspire"
+
+// errCredentialsNotFound is the message docker expects when there are no
+// credentials for a registry.
+const errCredentialsNotFound = "credentials not found in native keychain"
+
+var dockerPrefix = "docker"
+
+func init() {
+	RootCmd.AddCommand(dockerCredentialCmd)
+	dockerCredentialCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom stored credentials will be encrypted (default: the group bound to their directory)")
+	dockerCredentialCmd.Flags().StringVar(&dockerPrefix, "prefix", dockerPrefix, "directory of the vault to keep credentials in")
+}
+
+// dockerCredentials is the JSON docker exchanges with its helpers.
+type dockerCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// dockerSecretName returns the name of the secret holding the credentials
+// for a registry.
+func dockerSecretName(serverURL string) (string, error) {
+	serverURL = strings.TrimSpace(serverURL)
+	s := serverURL
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no registry in server URL %q", serverURL)
+	}
+	elems := []string{strings.Replace(u.Host, ":", "_", -1)}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		elems = append(elems, strings.Split(p, "/")...)
+	}
+	name, ok := credentialName(dockerPrefix, elems...)
+	if !ok {
+		return "", fmt.Errorf("server URL %q can't be mapped to a secret name", serverURL)
+	}
+	return name, nil
+}
+
+func dockerCredential(cmd *cobra.Command, args []string) {
+
+	switch args[0] {
+	case "get":
+		name := readServerURL()
+		v := openVault()
+		if !v.Exists(name) {
+			dockerNotFound()
+		}
+		r := readCredentialRecord(v, name)
+		creds := dockerCredentials{}
+		creds.Secret, _ = r.Field(vault.PasswordField)
+		creds.Username, _ = r.Field("username")
+		creds.ServerURL, _ = r.Field("url")
+		if err := json.NewEncoder(os.Stdout).Encode(creds); err != nil {
+			exitf(err, "Couldn't write credentials")
+		}
+
+	case "store":
+		creds := dockerCredentials{}
+		if err := json.NewDecoder(os.Stdin).Decode(&creds); err != nil {
+			exitf(err, "Couldn't read credentials from docker")
+		}
+		name, err := dockerSecretName(creds.ServerURL)
+		if err != nil {
+			exitf(err, "Couldn't store credentials for %v", creds.ServerURL)
+		}
+
+		v := openVault()
+		lock, err := v.LockSecret(name)
+		if err != nil {
+			exitf(err, "Couldn't lock secret %v", name)
+		}
+		defer lock.Unlock()
+
+		// some registries take a whole JSON key as the secret, which
+		// won't fit on the first line
+		r := vault.NewRecord(name)
+		if strings.ContainsAny(creds.Secret, "\r\n") {
+			r = vault.NewRecordFormat(name, vault.FormatJSON)
+		}
+		for _, f := range []vault.Field{
+			{Key: vault.PasswordField, Value: creds.Secret},
+			{Key: "username", Value: creds.Username},
+			{Key: "url", Value: creds.ServerURL},
+		} {
+			if err := r.Set(f.Key, f.Value); err != nil {
+				exitf(err, "Couldn't store credentials in secret %v", name)
+			}
+		}
+		data, err := r.Bytes()
+		if err != nil {
+			exitf(err, "Couldn't store credentials in secret %v", name)
+		}
+
+		group, err := putGroup(v, name)
+		if err != nil {
+			exitf(err, "Couldn't tell which group secret %v is encrypted for", name)
+		}
+		if err := v.WriteSecret(name, group, data); err != nil {
+			exitf(err, "Couldn't store credentials in secret %v", name)
+		}
+
+	case "erase":
+		name := readServerURL()
+		v := openVault()
+		if !v.Exists(name) {
+			dockerNotFound()
+		}
+		if err := v.RemoveSecret(name, false); err != nil {
+			exitf(err, "Couldn't erase secret %v", name)
+		}
+
+	case "list":
+		v := openVault()
+		names, err := v.Secrets()
+		if err != nil {
+			exitf(err, "Couldn't list secrets")
+		}
+		list := map[string]string{}
+		for _, name := range names {
+			if !strings.HasPrefix(name, dockerPrefix+"/") {
+				continue
+			}
+			r := readCredentialRecord(v, name)
+			serverURL, err := r.Field("url")
+			if err != nil {
+				continue
+			}
+			list[serverURL], _ = r.Field("username")
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(list); err != nil {
+			exitf(err, "Couldn't write credentials")
+		}
+
+	default:
+		usagef("Unknown docker credential helper action %v", args[0])
+	}
+}
+
+// readServerURL reads the server URL docker passes to get and erase, and
+// returns the name of its secret.
+func readServerURL() string {
+	in, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		exitf(err, "Couldn't read server URL from docker")
+	}
+	name, err := dockerSecretName(string(in))
+	if err != nil {
+		exitf(err, "Couldn't find credentials for %v", strings.TrimSpace(string(in)))
+	}
+	return name
+}
+
+// dockerNotFound tells docker there are no credentials for the registry.
+func dockerNotFound() {
+	fmt.Println(errCredentialsNotFound)
+	runCleanups()
+	os.Exit(ExitError)
+}
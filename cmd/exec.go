@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] [--] <command> [args...]",
+	Short: "run a command with secrets in its environment",
+	Long: `Run a command with secrets in its environment, so they never appear on a
+command line or in shell history. Each --env VAR=secret sets VAR to the
+secret, or to one field of a structured secret with VAR=secret#field.
+--env-file reads more such lines from a file, where blank lines and lines
+starting with # are ignored. --env takes precedence over --env-file, and
+both over the environment conspire is run with.
+
+Each secret is decrypted once, so you are asked for a passphrase at most
+once. A whole secret loses its trailing newlines, as it would in
+$(conspire secret show ...).
+
+On Unix, conspire replaces itself with the command, which so gets signals
+directly and its exit status is the one conspire exits with. Elsewhere,
+conspire waits for the command and exits with its status.
+
+Example:
+
+$ cat prod/app.env
+# database
+DB_USER=prod/db#username
+DB_PASS=prod/db#password
+$ conspire exec --env API_TOKEN=prod/api/token --env-file prod/app.env -- ./server
+`,
+	Run: execWithSecrets,
+}
+
+var envFlag []string
+var envFileFlag []string
+
+func init() {
+	RootCmd.AddCommand(execCmd)
+	execCmd.Flags().StringArrayVarP(&envFlag, "env", "E", nil, "set VAR to a secret, as VAR=secret or VAR=secret#field")
+	execCmd.Flags().StringArrayVar(&envFileFlag, "env-file", nil, "read VAR=secret lines from this file")
+	execCmd.Flags().SetInterspersed(false)
+}
+
+// envRef is an environment variable to be set from a secret.
+type envRef struct {
+	name, ref string
+}
+
+func execWithSecrets(cmd *cobra.Command, args []string) {
+
+	if len(args) < 1 {
+		usagef("You must specify a command to run")
+	}
+
+	var refs []envRef
+	for _, f := range envFileFlag {
+		refs = append(refs, readEnvFile(f)...)
+	}
+	for _, e := range envFlag {
+		r, ok := parseEnvRef(e)
+		if !ok {
+			usagef("Environment variables must be given as VAR=secret, not %v", e)
+		}
+		refs = append(refs, r)
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		exitf(err, "Couldn't find command %v", args[0])
+	}
+
+	secrets := newSecretCache(openVault())
+	set := map[string]string{}
+	for _, r := range refs {
+		value, err := secrets.Lookup(r.ref)
+		if err != nil {
+			exitf(err, "Couldn't read secret %v for %v", r.ref, r.name)
+		}
+		if strings.ContainsRune(value, 0) {
+			usagef("Secret %v can't be put in the environment, it contains a NUL byte", r.ref)
+		}
+		set[r.name] = value
+	}
+
+	env := []string{}
+	for _, e := range os.Environ() {
+		if _, ok := set[strings.SplitN(e, "=", 2)[0]]; !ok {
+			env = append(env, e)
+		}
+	}
+	for _, r := range refs {
+		if value, ok := set[r.name]; ok {
+			env = append(env, r.name+"="+value)
+			delete(set, r.name)
+		}
+	}
+
+	if Verbose {
+		fmt.Fprintf(os.Stderr, "Running %v with %v secrets in its environment\n", path, len(refs))
+	}
+
+	runCleanups()
+	code, err := execCommand(path, args, env)
+	if err != nil {
+		exitf(err, "Couldn't run %v", args[0])
+	}
+	os.Exit(code)
+}
+
+// parseEnvRef parses VAR=secret.
+func parseEnvRef(s string) (envRef, bool) {
+	kv := strings.SplitN(strings.TrimSpace(s), "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" || strings.ContainsAny(kv[0], " \t") {
+		return envRef{}, false
+	}
+	return envRef{kv[0], kv[1]}, true
+}
+
+// readEnvFile reads the VAR=secret lines of an --env-file.
+func readEnvFile(path string) []envRef {
+	f, err := os.Open(path)
+	if err != nil {
+		exitf(err, "Couldn't read environment file %v", path)
+	}
+	defer f.Close()
+
+	var refs []envRef
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		r, ok := parseEnvRef(l)
+		if !ok {
+			usagef("%v:%v: expected VAR=secret, not %v", path, line, l)
+		}
+		refs = append(refs, r)
+	}
+	if err := scanner.Err(); err != nil {
+		exitf(err, "Couldn't read environment file %v", path)
+	}
+	return refs
+}
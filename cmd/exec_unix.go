@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"syscall"
+)
+
+// execCommand replaces conspire with the command, so that it gets signals
+// sent to conspire and its exit status is conspire's. It only returns if
+// the command couldn't be run.
+func execCommand(path string, args, env []string) (int, error) {
+	return ExitError, syscall.Exec(path, args, env)
+}
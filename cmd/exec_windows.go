@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// execCommand runs the command and returns its exit status. Windows can't
+// replace a running process, so conspire waits for the command instead,
+// leaving Ctrl-C to the command.
+func execCommand(path string, args, env []string) (int, error) {
+	c := exec.Command(path, args[1:]...)
+	c.Env = env
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	ignoreInterrupts(true)
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return ExitError, err
+	}
+	return ExitOK, nil
+}
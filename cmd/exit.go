@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+// Process exit codes. These are part of the command line interface, so
+// scripts can tell failures apart; don't renumber them.
+const (
+	ExitOK            = 0
+	ExitError         = 1  // any failure not listed below
+	ExitUsage         = 2  // bad arguments or flags
+	ExitNotFound      = 3  // the secret doesn't exist
+	ExitGroupNotFound = 4  // the group doesn't exist
+	ExitBadGroup      = 5  // the group file can't be read as a keyring
+	ExitDecrypt       = 6  // decryption failed, e.g. wrong passphrase
+	ExitNoKey         = 7  // no private key for any recipient of the secret
+	ExitBadKeyId      = 8  // a key id was malformed
+	ExitNoAgent       = 9  // gpg-agent was needed but couldn't be reached
+	ExitBadName       = 10 // a secret name or directory was malformed
+	ExitLocked        = 11 // another process holds a lock on the secret or group
+	ExitConflict      = 12 // the secret changed while it was being edited
+	ExitExists        = 13 // the secret to be created already exists
+	ExitBadFormat     = 14 // the secret isn't structured, or its structure was broken
+	ExitNoField       = 15 // the secret has no such field
+)
+
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{vault.ErrNotFound, ExitNotFound},
+	{vault.ErrGroupNotFound, ExitGroupNotFound},
+	{vault.ErrBadGroup, ExitBadGroup},
+	{vault.ErrNoAgent, ExitNoAgent},
+	{vault.ErrNoKey, ExitNoKey},
+	{vault.ErrDecrypt, ExitDecrypt},
+	{vault.ErrBadKeyId, ExitBadKeyId},
+	{vault.ErrBadName, ExitBadName},
+	{vault.ErrLocked, ExitLocked},
+	{vault.ErrConflict, ExitConflict},
+	{vault.ErrExists, ExitExists},
+	{vault.ErrBadFormat, ExitBadFormat},
+	{vault.ErrNoField, ExitNoField},
+}
+
+// exitCode returns the process exit code for err.
+func exitCode(err error) int {
+	for _, e := range exitCodes {
+		if errors.Is(err, e.kind) {
+			return e.code
+		}
+	}
+	return ExitError
+}
+
+// cleanups are run before exiting, even on a signal, to scrub plaintext
+// and release locks.
+var (
+	cleanupMu   sync.Mutex
+	cleanups    []func()
+	interruptOK bool
+)
+
+// atExit arranges for f to be called when the program exits, whether
+// normally, through exitf or usagef, or on a signal.
+func atExit(f func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanups = append(cleanups, f)
+}
+
+func runCleanups() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	cleanups = nil
+}
+
+// ignoreInterrupts sets whether an interrupt is left to a child process,
+// such as the editor, rather than ending the program.
+func ignoreInterrupts(ignore bool) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	interruptOK = ignore
+}
+
+// handleSignals runs the cleanups and exits when the program is
+// interrupted or terminated.
+func handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			cleanupMu.Lock()
+			ignore := interruptOK && sig == os.Interrupt
+			cleanupMu.Unlock()
+			if ignore {
+				continue
+			}
+
+			fmt.Fprintf(os.Stderr, "\nInterrupted by %v, cleaning up\n", sig)
+			runCleanups()
+			code := ExitError
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		}
+	}()
+}
+
+// exitf prints a message and the error that caused it to stderr, then
+// exits with the code for that kind of error.
+func exitf(err error, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	fmt.Fprintln(os.Stderr, err)
+	runCleanups()
+	os.Exit(exitCode(err))
+}
+
+// exitWith exits with code after a failure that has already been
+// reported, such as secrets that couldn't be recrypted.
+func exitWith(code int) {
+	runCleanups()
+	os.Exit(code)
+}
+
+// usagef prints a usage message to stderr and exits with ExitUsage.
+func usagef(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	runCleanups()
+	os.Exit(ExitUsage)
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var gitCredentialCmd = &cobra.Command{
+	Use:   "git-credential <get|store|erase>",
+	Short: "act as a git credential helper",
+	Long: `Act as a git credential helper, keeping the credentials git asks for in
+the vault. Set it up with
+
+$ git config --global credential.helper 'conspire git-credential --group ops'
+
+Credentials for https://git.example.com are kept in the secret
+git/https/git.example.com, with the password on the first line and the
+username in a "username: " line. If git is told to use the path of the
+repository too (credential.useHttpPath), the most specific secret is used,
+from git/https/git.example.com/team/repo.git up to git/https/git.example.com.
+A port is kept after an underscore, as in git.example.com_8443.
+
+Stored credentials are encrypted for --group or, without it, the group
+bound to the git directory of the vault (see "conspire group bind").
+Unknown operations, and credentials conspire doesn't have, are ignored, so
+git can go on to ask another helper or the user.`,
+	Args: cobra.ExactArgs(1),
+	Run:  gitCredential,
+}
+
+var prefixFlag = "git"
+
+func init() {
+	RootCmd.AddCommand(gitCredentialCmd)
+	gitCredentialCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom stored credentials will be encrypted (default: the group bound to their directory)")
+	gitCredentialCmd.Flags().StringVar(&prefixFlag, "prefix", prefixFlag, "directory of the vault to keep credentials in")
+}
+
+// readCredential reads the key=value lines of git's credential helper
+// protocol, up to a blank line or the end of input.
+func readCredential(r io.Reader) (map[string]string, error) {
+	attrs := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad credential line %q", line)
+		}
+		attrs[kv[0]] = kv[1]
+	}
+
+	// git passes the parts of the URL, but take a url too
+	if u, err := url.Parse(attrs["url"]); attrs["url"] != "" && attrs["protocol"] == "" && err == nil {
+		attrs["protocol"], attrs["host"] = u.Scheme, u.Host
+		attrs["path"] = strings.TrimPrefix(u.Path, "/")
+		if u.User != nil && attrs["username"] == "" {
+			attrs["username"] = u.User.Username()
+		}
+	}
+	return attrs, scanner.Err()
+}
+
+// credentialNames returns the names a credential may be kept under, most
+// specific first, or nil if the request can't be mapped to a name safely.
+func credentialNames(attrs map[string]string) []string {
+	host := strings.Replace(attrs["host"], ":", "_", -1)
+	var elems []string
+	if p := strings.Trim(attrs["path"], "/"); p != "" {
+		elems = strings.Split(p, "/")
+	}
+
+	var names []string
+	for i := len(elems); i >= 0; i-- {
+		name, ok := credentialName(prefixFlag, append([]string{attrs["protocol"], host}, elems[:i]...)...)
+		if !ok {
+			return nil
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// credentialName joins the elements of a credential's secret name under
+// prefix. It fails if any element is empty, "." or "..", or has a slash
+// in it, so that a server can't name a secret outside prefix and be sent
+// its contents.
+func credentialName(prefix string, elems ...string) (string, bool) {
+	for _, e := range elems {
+		if e == "" || e == "." || e == ".." || strings.ContainsAny(e, "/\\") {
+			return "", false
+		}
+	}
+	name := path.Join(append([]string{prefix}, elems...)...)
+	if !strings.HasPrefix(name, path.Clean(prefix)+"/") {
+		return "", false
+	}
+	return name, true
+}
+
+func gitCredential(cmd *cobra.Command, args []string) {
+
+	attrs, err := readCredential(os.Stdin)
+	if err != nil {
+		exitf(err, "Couldn't read credential from git")
+	}
+	names := credentialNames(attrs)
+	if len(names) == 0 {
+		return
+	}
+
+	switch args[0] {
+	case "get":
+		v := openVault()
+		for _, name := range names {
+			if !v.Exists(name) {
+				continue
+			}
+			r := readCredentialRecord(v, name)
+			password, _ := r.Field(vault.PasswordField)
+			username, _ := r.Field("username")
+			if attrs["username"] != "" && username != "" && username != attrs["username"] {
+				continue
+			}
+			if username != "" {
+				fmt.Printf("username=%v\n", username)
+			}
+			fmt.Printf("password=%v\n", password)
+			return
+		}
+
+	case "store":
+		if attrs["password"] == "" {
+			return
+		}
+		v := openVault()
+		name := names[0]
+
+		lock, err := v.LockSecret(name)
+		if err != nil {
+			exitf(err, "Couldn't lock secret %v", name)
+		}
+		defer lock.Unlock()
+
+		// git stores credentials after each use, so leave them alone if
+		// they haven't changed
+		r := vault.NewRecord(name)
+		if v.Exists(name) {
+			r = readCredentialRecord(v, name)
+			password, _ := r.Field(vault.PasswordField)
+			username, _ := r.Field("username")
+			if password == attrs["password"] && username == attrs["username"] {
+				return
+			}
+		}
+
+		if err := r.Set(vault.PasswordField, attrs["password"]); err != nil {
+			exitf(err, "Couldn't store credential in secret %v", name)
+		}
+		if attrs["username"] != "" {
+			if err := r.Set("username", attrs["username"]); err != nil {
+				exitf(err, "Couldn't store credential in secret %v", name)
+			}
+		}
+		data, err := r.Bytes()
+		if err != nil {
+			exitf(err, "Couldn't store credential in secret %v", name)
+		}
+
+		group, err := putGroup(v, name)
+		if err != nil {
+			exitf(err, "Couldn't tell which group secret %v is encrypted for", name)
+		}
+		if err := v.WriteSecret(name, group, data); err != nil {
+			exitf(err, "Couldn't store credential in secret %v", name)
+		}
+
+	case "erase":
+		v := openVault()
+		for _, name := range names {
+			if !v.Exists(name) {
+				continue
+			}
+			if attrs["username"] != "" {
+				username, _ := readCredentialRecord(v, name).Field("username")
+				if username != "" && username != attrs["username"] {
+					continue
+				}
+			}
+			if err := v.RemoveSecret(name, false); err != nil {
+				exitf(err, "Couldn't erase secret %v", name)
+			}
+			return
+		}
+	}
+}
+
+// readCredentialRecord reads a secret holding a credential.
+func readCredentialRecord(v *vault.Vault, name string) *vault.Record {
+	s, err := v.ReadSecret(name)
+	if err != nil {
+		exitf(err, "Couldn't read secret %v", name)
+	}
+	r, err := s.Record()
+	if err != nil {
+		exitf(err, "Secret %v doesn't hold a credential", name)
+	}
+	return r
+}
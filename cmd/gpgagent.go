@@ -20,51 +20,169 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/hex"
+	"bytes"
+	"crypto/sha1"
 	"errors"
 	"fmt"
-
 	"io"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/zoidbergconspiracy/conspire/vault"
 )
 
-// Conn is a connection to the GPG agent.
+// Conn is a connection to the GPG agent, which speaks the Assuan
+// protocol.
 type Conn struct {
 	c  io.ReadWriteCloser
 	br *bufio.Reader
 }
 
 var (
-	ErrNoAgent = errors.New("GPG_AGENT_INFO not set in environment")
+	ErrNoAgent = fmt.Errorf("%w: no gpg-agent socket found", vault.ErrNoAgent)
 	ErrNoData  = errors.New("GPG_ERR_NO_DATA cache miss")
 	ErrCancel  = errors.New("gpgagent: Cancel")
 )
 
-// NewGpgAgentConn connects to the GPG Agent as described in the
-// GPG_AGENT_INFO environment variable.
+// Error codes from libgpg-error, without the error source.
+const (
+	gpgErrNoData   = 58
+	gpgErrCanceled = 99
+)
+
+// AgentError is an ERR response from the agent.
+type AgentError struct {
+	Code        uint32
+	Description string
+}
+
+func (e *AgentError) Error() string {
+	return fmt.Sprintf("gpgagent: error %d: %s", e.Code, e.Description)
+}
+
+// Is lets errors.Is match ErrNoData and ErrCancel.
+func (e *AgentError) Is(target error) bool {
+	switch target {
+	case ErrNoData:
+		return e.Code&0xffff == gpgErrNoData
+	case ErrCancel:
+		return e.Code&0xffff == gpgErrCanceled
+	}
+	return false
+}
+
+// NewGpgAgentConn connects to the GPG Agent. The socket is the one named
+// in GPG_AGENT_INFO, if set, otherwise it is found the way GnuPG 2.1 and
+// later find it; see agentSockets.
 func NewGpgAgentConn() (*Conn, error) {
-	sp := strings.SplitN(os.Getenv("GPG_AGENT_INFO"), ":", 3)
-	if len(sp) == 0 || len(sp[0]) == 0 {
-		return nil, ErrNoAgent
+	for _, sock := range agentSockets() {
+		addr := &net.UnixAddr{Net: "unix", Name: sock}
+		uc, err := net.DialUnix("unix", nil, addr)
+		if err != nil {
+			continue
+		}
+		return NewConn(uc)
 	}
-	addr := &net.UnixAddr{Net: "unix", Name: sp[0]}
-	uc, err := net.DialUnix("unix", nil, addr)
+	return nil, ErrNoAgent
+}
+
+// NewConn starts an Assuan session with the agent on an established
+// connection, such as a pipe to a test server.
+func NewConn(rwc io.ReadWriteCloser) (*Conn, error) {
+	c := &Conn{rwc, bufio.NewReader(rwc)}
+	line, err := c.readLine()
 	if err != nil {
+		rwc.Close()
 		return nil, err
 	}
-	br := bufio.NewReader(uc)
-	lineb, err := br.ReadSlice('\n')
+	if !isOK(line) {
+		rwc.Close()
+		return nil, fmt.Errorf("gpgagent: didn't get OK; got %q", line)
+	}
+	return c, nil
+}
+
+// agentSockets returns the places the agent's socket may be, in the order
+// they should be tried: GPG_AGENT_INFO, the per-user runtime directory
+// used since GnuPG 2.1.13, the GnuPG home directory, and finally whatever
+// gpgconf reports if it is installed.
+func agentSockets() []string {
+	var socks []string
+
+	if info := os.Getenv("GPG_AGENT_INFO"); info != "" {
+		socks = append(socks, strings.SplitN(info, ":", 2)[0])
+	}
+
+	home, err := filepath.Abs(vault.GnuPGHome())
 	if err != nil {
-		return nil, err
+		home = vault.GnuPGHome()
 	}
-	line := string(lineb)
-	if !strings.HasPrefix(line, "OK") {
-		return nil, fmt.Errorf("gpgagent: didn't get OK; got %q", line)
+
+	for _, run := range []string{"/run/user", "/var/run/user"} {
+		dir := filepath.Join(run, strconv.Itoa(os.Getuid()), "gnupg")
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		// homes other than ~/.gnupg get their own subdirectory
+		if home != filepath.Join(os.Getenv("HOME"), ".gnupg") {
+			sum := sha1.Sum([]byte(home))
+			dir = filepath.Join(dir, "d."+zbase32(sum[:15]))
+		}
+		socks = append(socks, filepath.Join(dir, "S.gpg-agent"))
+		break
 	}
-	return &Conn{uc, br}, nil
+
+	socks = append(socks, redirectedSocket(filepath.Join(home, "S.gpg-agent")))
+
+	if out, err := exec.Command("gpgconf", "--list-dirs", "agent-socket").Output(); err == nil {
+		socks = append(socks, strings.TrimSpace(string(out)))
+	}
+
+	return socks
+}
+
+// redirectedSocket follows an Assuan socket redirection file, which
+// GnuPG leaves in place of a socket on file systems that don't support
+// sockets.
+func redirectedSocket(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return path
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, []byte("%Assuan%\n")) {
+		return path
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "socket=") {
+			return os.ExpandEnv(strings.TrimPrefix(line, "socket="))
+		}
+	}
+	return path
+}
+
+// zbase32 encodes data in z-base-32, as GnuPG does for socket directory
+// names. len(data)*8 must be a multiple of 5.
+func zbase32(data []byte) string {
+	const alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+	var out []byte
+	var acc uint
+	bits := 0
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out = append(out, alphabet[(acc>>uint(bits))&31])
+		}
+	}
+	return string(out)
 }
 
 func (c *Conn) Close() error {
@@ -72,6 +190,168 @@ func (c *Conn) Close() error {
 	return c.c.Close()
 }
 
+// InquireFunc answers an INQUIRE from the agent with the data it asks
+// for, named by keyword.
+type InquireFunc func(keyword, args string) ([]byte, error)
+
+// StatusFunc receives the status lines sent by the agent while it
+// processes a command.
+type StatusFunc func(keyword, args string)
+
+// Transact sends a command to the agent and returns the data it sends in
+// reply. Inquiries are answered by inquire, or with no data if inquire is
+// nil, and status lines are passed to status if it is not nil. An ERR
+// response is returned as an *AgentError.
+func (c *Conn) Transact(cmd string, inquire InquireFunc, status StatusFunc) ([]byte, error) {
+	if _, err := fmt.Fprintf(c.c, "%s\n", cmd); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		keyword, args := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			keyword, args = line[:i], line[i+1:]
+		}
+
+		switch keyword {
+		case "OK":
+			return data, nil
+
+		case "ERR":
+			return nil, parseAgentError(args)
+
+		case "D":
+			data = append(data, Unescape(args)...)
+
+		case "S":
+			if status != nil {
+				kw, rest := args, ""
+				if i := strings.IndexByte(args, ' '); i >= 0 {
+					kw, rest = args[:i], args[i+1:]
+				}
+				status(kw, rest)
+			}
+
+		case "INQUIRE":
+			kw, rest := args, ""
+			if i := strings.IndexByte(args, ' '); i >= 0 {
+				kw, rest = args[:i], args[i+1:]
+			}
+			var resp []byte
+			if inquire != nil {
+				resp, err = inquire(kw, rest)
+			}
+			if err != nil {
+				// the agent answers the cancelled inquiry with ERR
+				if _, err := fmt.Fprintf(c.c, "CAN\n"); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := c.sendData(resp); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// command sends a command that returns no data.
+func (c *Conn) command(cmd string) error {
+	_, err := c.Transact(cmd, nil, nil)
+	return err
+}
+
+// readLine reads a response line from the agent, skipping comments.
+func (c *Conn) readLine() (string, error) {
+	for {
+		line, err := c.br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+}
+
+// sendData sends data to the agent in response to an INQUIRE.
+func (c *Conn) sendData(data []byte) error {
+	esc := Escape(data)
+	for len(esc) > 0 {
+		// keep lines within the Assuan limit of 1000 bytes
+		n := len(esc)
+		if n > 900 {
+			n = 900
+			// don't split an escape sequence
+			for esc[n-1] == '%' || esc[n-2] == '%' {
+				n--
+			}
+		}
+		if _, err := fmt.Fprintf(c.c, "D %s\n", esc[:n]); err != nil {
+			return err
+		}
+		esc = esc[n:]
+	}
+	_, err := fmt.Fprintf(c.c, "END\n")
+	return err
+}
+
+func isOK(line string) bool {
+	return line == "OK" || strings.HasPrefix(line, "OK ")
+}
+
+// parseAgentError parses the arguments of an ERR line, which are the
+// error code and its description.
+func parseAgentError(args string) error {
+	code, desc := args, ""
+	if i := strings.IndexByte(args, ' '); i >= 0 {
+		code, desc = args[:i], args[i+1:]
+	}
+	n, err := strconv.ParseUint(code, 10, 32)
+	if err != nil {
+		return fmt.Errorf("gpgagent: malformed error %q", args)
+	}
+	return &AgentError{uint32(n), Unescape(desc)}
+}
+
+// Escape percent-escapes the characters that may not appear literally in
+// an Assuan line.
+func Escape(data []byte) string {
+	var esc []byte
+	for _, b := range data {
+		switch b {
+		case '%', '\r', '\n':
+			esc = append(esc, []byte(fmt.Sprintf("%%%02X", b))...)
+		default:
+			esc = append(esc, b)
+		}
+	}
+	return string(esc)
+}
+
+// Unescape reverses Escape.
+func Unescape(s string) string {
+	var data []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				data = append(data, byte(b))
+				i += 2
+				continue
+			}
+		}
+		data = append(data, s[i])
+	}
+	return string(data)
+}
+
 // PassphraseRequest is a request to get a passphrase from the GPG
 // Agent.
 type PassphraseRequest struct {
@@ -84,53 +364,13 @@ type PassphraseRequest struct {
 }
 
 func (c *Conn) RemoveFromCache(cacheKey string) error {
-	_, err := fmt.Fprintf(c.c, "CLEAR_PASSPHRASE %s\n", url.QueryEscape(cacheKey))
-	if err != nil {
-		return err
-	}
-	lineb, err := c.br.ReadSlice('\n')
-	if err != nil {
-		return err
-	}
-	line := string(lineb)
-	if !strings.HasPrefix(line, "OK") {
-		return fmt.Errorf("gpgagent: CLEAR_PASSPHRASE returned %q", line)
-	}
-	return nil
+	return c.command("CLEAR_PASSPHRASE " + url.QueryEscape(cacheKey))
 }
 
 func (c *Conn) GetPassphrase(pr *PassphraseRequest) (passphrase string, outerr error) {
-	defer func() {
-		if e, ok := recover().(string); ok {
-			passphrase = ""
-			outerr = errors.New(e)
-		}
-	}()
-	set := func(cmd string, val string) {
-		if val == "" {
-			return
-		}
-		_, err := fmt.Fprintf(c.c, "%s %s\n", cmd, val)
-		if err != nil {
-			panic("gpgagent: failed to send " + cmd)
-		}
-		line, _, err := c.br.ReadLine()
-		if err != nil {
-			panic("gpgagent: failed to read " + cmd)
-		}
-		if !strings.HasPrefix(string(line), "OK") {
-			panic("gpgagent: response to " + cmd + " was " + string(line))
-		}
-	}
-	if d := os.Getenv("DISPLAY"); d != "" {
-		set("OPTION", "display="+d)
-	}
-	tty, err := os.Readlink("/proc/self/fd/0")
-	if err == nil {
-		set("OPTION", "ttyname="+tty)
-	}
-	set("OPTION", "ttytype="+os.Getenv("TERM"))
-	opts := ""
+	c.setOptions()
+
+	opts := "--data "
 	if pr.NoAsk {
 		opts += "--no-ask "
 	}
@@ -142,35 +382,118 @@ func (c *Conn) GetPassphrase(pr *PassphraseRequest) (passphrase string, outerr e
 		return url.QueryEscape(s)
 	}
 
-	_, err = fmt.Fprintf(c.c, "GET_PASSPHRASE %s%s %s %s %s\n",
+	data, err := c.Transact(fmt.Sprintf("GET_PASSPHRASE %s%s %s %s %s",
 		opts,
 		url.QueryEscape(pr.CacheKey),
 		encOrX(pr.Error),
 		encOrX(pr.Prompt),
-		encOrX(pr.Desc))
-	if err != nil {
+		encOrX(pr.Desc)), nil, nil)
+	switch {
+	case errors.Is(err, ErrNoData):
+		return "", ErrNoData
+	case errors.Is(err, ErrCancel):
+		return "", ErrCancel
+	case err != nil:
 		return "", err
 	}
-	lineb, err := c.br.ReadSlice('\n')
+	return string(data), nil
+}
+
+// setOptions tells the agent where to show pinentry.
+func (c *Conn) setOptions() {
+	if d := os.Getenv("DISPLAY"); d != "" {
+		c.command("OPTION display=" + d)
+	}
+	if tty, err := os.Readlink("/proc/self/fd/0"); err == nil && tty != os.DevNull {
+		c.command("OPTION ttyname=" + tty)
+	}
+	if t := os.Getenv("TERM"); t != "" {
+		c.command("OPTION ttytype=" + t)
+	}
+}
+
+// HaveKey reports whether the agent holds the private key with the given
+// keygrip. It implements vault.Agent.
+func (c *Conn) HaveKey(keygrip string) bool {
+	return c.command("HAVEKEY "+keygrip) == nil
+}
+
+// PKDecrypt asks the agent to decrypt an RSA ciphertext with the private
+// key with the given keygrip, using pinentry to ask for its passphrase
+// if necessary, and returns it without PKCS #1 v1.5 padding. It
+// implements vault.Agent.
+func (c *Conn) PKDecrypt(keygrip, desc string, ciphertext []byte) ([]byte, error) {
+	c.setOptions()
+	if err := c.command("SETKEY " + keygrip); err != nil {
+		return nil, err
+	}
+	if err := c.command("SETKEYDESC " + url.QueryEscape(desc)); err != nil {
+		return nil, err
+	}
+
+	padding := -1
+	data, err := c.Transact("PKDECRYPT",
+		func(keyword, args string) ([]byte, error) {
+			if keyword != "CIPHERTEXT" {
+				// e.g. PINENTRY_LAUNCHED, which needs no answer
+				return nil, nil
+			}
+			return []byte(fmt.Sprintf("(7:enc-val(3:rsa(1:a%d:%s)))", len(ciphertext), ciphertext)), nil
+		},
+		func(keyword, args string) {
+			if keyword == "PADDING" {
+				padding, _ = strconv.Atoi(args)
+			}
+		})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	line := string(lineb)
-	if strings.HasPrefix(line, "OK ") {
-		decb, err := hex.DecodeString(line[3 : len(line)-1])
-		if err != nil {
-			return "", err
-		}
-		return string(decb), nil
-	}
-	fields := strings.Split(line, " ")
-	if len(fields) >= 2 && fields[0] == "ERR" {
-		switch fields[1] {
-		case "67108922":
-			return "", ErrNoData
-		case "83886179":
-			return "", ErrCancel
-		}
+
+	value, err := parseValue(data)
+	if err != nil {
+		return nil, err
+	}
+	if padding == 0 {
+		return value, nil
+	}
+	return unpadPKCS1(value)
+}
+
+// parseValue returns the plaintext from the result of PKDECRYPT, which
+// is the S-expression (5:value<length>:<plaintext>).
+func parseValue(data []byte) ([]byte, error) {
+	const prefix = "(5:value"
+	if !strings.HasPrefix(string(data), prefix) {
+		return nil, fmt.Errorf("gpgagent: unexpected PKDECRYPT result %q", data)
+	}
+	rest := data[len(prefix):]
+	i := strings.IndexByte(string(rest), ':')
+	if i < 0 {
+		return nil, fmt.Errorf("gpgagent: unexpected PKDECRYPT result %q", data)
+	}
+	n, err := strconv.Atoi(string(rest[:i]))
+	if err != nil || len(rest) < i+1+n {
+		return nil, fmt.Errorf("gpgagent: unexpected PKDECRYPT result %q", data)
+	}
+	return rest[i+1 : i+1+n], nil
+}
+
+// unpadPKCS1 removes PKCS #1 v1.5 encryption padding, which gpg-agent
+// leaves in place for software keys.
+func unpadPKCS1(block []byte) ([]byte, error) {
+	// the leading zero byte is lost when the value is treated as a number
+	if len(block) > 0 && block[0] == 0 {
+		block = block[1:]
+	}
+	if len(block) < 2 || block[0] != 2 {
+		return nil, errors.New("gpgagent: bad PKCS #1 padding in decrypted value")
+	}
+	i := 1
+	for i < len(block) && block[i] != 0 {
+		i++
+	}
+	if i == len(block) {
+		return nil, errors.New("gpgagent: bad PKCS #1 padding in decrypted value")
 	}
-	return "", errors.New(line)
+	return block[i+1:], nil
 }
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeAgent starts an agent on the far end of a pipe, which greets the
+// client and then runs serve, and returns a connection to it.
+func fakeAgent(t *testing.T, serve func(br *bufio.Reader, w io.Writer)) *Conn {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		fmt.Fprintf(server, "OK Pleased to meet you\n")
+		serve(bufio.NewReader(server), server)
+	}()
+	c, err := NewConn(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// expectLine reads a line from the client and reports whether it is want.
+func expectLine(t *testing.T, br *bufio.Reader, want string) bool {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Errorf("agent: reading %q: %v", want, err)
+		return false
+	}
+	if line = strings.TrimSuffix(line, "\n"); line != want {
+		t.Errorf("agent: got %q, want %q", line, want)
+		return false
+	}
+	return true
+}
+
+func TestTransactData(t *testing.T) {
+	c := fakeAgent(t, func(br *bufio.Reader, w io.Writer) {
+		if expectLine(t, br, "GETINFO version") {
+			io.WriteString(w, "# a comment\nS PROGRESS tick 1 2\nD 50%25 off%0A\nS NEWLINE\nD second line\nOK\n")
+		}
+	})
+	defer c.Close()
+
+	var status []string
+	data, err := c.Transact("GETINFO version", nil, func(keyword, args string) {
+		status = append(status, keyword+"|"+args)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "50% off\nsecond line"; string(data) != want {
+		t.Errorf("data = %q, want %q", data, want)
+	}
+	if want := []string{"PROGRESS|tick 1 2", "NEWLINE|"}; strings.Join(status, ",") != strings.Join(want, ",") {
+		t.Errorf("status = %q, want %q", status, want)
+	}
+}
+
+func TestTransactInquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  []byte
+		err     error
+		client  []string // lines the agent expects in answer to INQUIRE
+		reply   string   // and its reply to them
+		want    string
+		wantErr error
+	}{
+		{"answered", []byte("100%\nsure"), nil, []string{"D 100%25%0Asure", "END"}, "D done\nOK\n", "done", nil},
+		{"empty", nil, nil, []string{"END"}, "OK\n", "", nil},
+		{"cancelled", nil, errors.New("no"), []string{"CAN"}, "ERR 83886179 Operation cancelled\n", "", ErrCancel},
+	}
+
+	for _, tt := range tests {
+		c := fakeAgent(t, func(br *bufio.Reader, w io.Writer) {
+			if !expectLine(t, br, "PKDECRYPT") {
+				return
+			}
+			fmt.Fprintf(w, "S INQUIRE_MAXLEN 4096\nINQUIRE CIPHERTEXT some args\n")
+			for _, line := range tt.client {
+				if !expectLine(t, br, line) {
+					return
+				}
+			}
+			fmt.Fprintf(w, "%s", tt.reply)
+		})
+
+		var keyword, args string
+		data, err := c.Transact("PKDECRYPT", func(kw, a string) ([]byte, error) {
+			keyword, args = kw, a
+			return tt.answer, tt.err
+		}, nil)
+		c.Close()
+
+		if keyword != "CIPHERTEXT" || args != "some args" {
+			t.Errorf("%s: inquired %q %q, want CIPHERTEXT %q", tt.name, keyword, args, "some args")
+		}
+		if !errors.Is(err, tt.wantErr) || (err != nil && tt.wantErr == nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: data = %q, want %q", tt.name, data, tt.want)
+		}
+	}
+}
+
+func TestTransactError(t *testing.T) {
+	tests := []struct {
+		line string
+		is   error // nil if the error is neither ErrNoData nor ErrCancel
+		code uint32
+		desc string
+	}{
+		{"ERR 67108922 No data <GPG Agent>", ErrNoData, 67108922, "No data <GPG Agent>"},
+		{"ERR 58", ErrNoData, 58, ""},
+		{"ERR 83886179 Operation cancelled <Pinentry>", ErrCancel, 83886179, "Operation cancelled <Pinentry>"},
+		{"ERR 67108875 Bad passphrase%0A", nil, 67108875, "Bad passphrase\n"},
+	}
+
+	for _, tt := range tests {
+		c := fakeAgent(t, func(br *bufio.Reader, w io.Writer) {
+			if expectLine(t, br, "GET_PASSPHRASE") {
+				fmt.Fprintf(w, "%s\n", tt.line)
+			}
+		})
+		_, err := c.Transact("GET_PASSPHRASE", nil, nil)
+		c.Close()
+
+		var ae *AgentError
+		if !errors.As(err, &ae) {
+			t.Errorf("%q: err = %v, want an *AgentError", tt.line, err)
+			continue
+		}
+		if ae.Code != tt.code || ae.Description != tt.desc {
+			t.Errorf("%q: got code %d %q, want %d %q", tt.line, ae.Code, ae.Description, tt.code, tt.desc)
+		}
+		for _, target := range []error{ErrNoData, ErrCancel} {
+			if errors.Is(err, target) != (target == tt.is) {
+				t.Errorf("%q: errors.Is(err, %v) = %v", tt.line, target, !(target == tt.is))
+			}
+		}
+	}
+}
+
+func TestSendData(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("hello")},
+		{"long", bytes.Repeat([]byte("x"), 5000)},
+		{"escapes", bytes.Repeat([]byte("%\n"), 2000)},
+	}
+	// move the escapes across the point lines are split at
+	for i := 1; i <= 3; i++ {
+		tests = append(tests, struct {
+			name string
+			data []byte
+		}{fmt.Sprintf("escapes offset %d", i), append(bytes.Repeat([]byte("a"), i), bytes.Repeat([]byte("%"), 1500)...)})
+	}
+
+	for _, tt := range tests {
+		var got []byte
+		c := fakeAgent(t, func(br *bufio.Reader, w io.Writer) {
+			if !expectLine(t, br, "INQUIRE_ME") {
+				return
+			}
+			fmt.Fprintf(w, "INQUIRE DATA\n")
+			for {
+				line, err := br.ReadString('\n')
+				if err != nil {
+					t.Errorf("%s: agent: %v", tt.name, err)
+					return
+				}
+				if len(line) > 1000 {
+					t.Errorf("%s: line of %d bytes, over the Assuan limit", tt.name, len(line))
+				}
+				line = strings.TrimSuffix(line, "\n")
+				if line == "END" {
+					break
+				}
+				if !strings.HasPrefix(line, "D ") {
+					t.Errorf("%s: agent: got %q, want a D line", tt.name, line)
+					return
+				}
+				if esc := line[2:]; strings.HasSuffix(esc, "%") || (len(esc) > 1 && esc[len(esc)-2] == '%') {
+					t.Errorf("%s: line ends within an escape: ...%q", tt.name, esc[len(esc)-2:])
+				}
+				got = append(got, Unescape(line[2:])...)
+			}
+			fmt.Fprintf(w, "OK\n")
+		})
+
+		_, err := c.Transact("INQUIRE_ME", func(keyword, args string) ([]byte, error) {
+			return tt.data, nil
+		}, nil)
+		c.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.data) {
+			t.Errorf("%s: agent got %d bytes, want %d", tt.name, len(got), len(tt.data))
+		}
+	}
+}
@@ -1,30 +1,54 @@
 package cmd
 
 import (
-	"encoding/binary"
-	"encoding/hex"
 	"fmt"
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
 )
 
 func init() {
 	RootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(lsGroupCmd)
 	groupCmd.AddCommand(listCmd)
 	groupCmd.AddCommand(addCmd)
 	groupCmd.AddCommand(delCmd)
+	groupCmd.AddCommand(bindCmd)
+	addCmd.Flags().BoolVarP(&recryptAfter, "recrypt", "r", false, "recrypt the group's secrets for the new members")
+	delCmd.Flags().BoolVarP(&recryptAfter, "recrypt", "r", false, "recrypt the group's secrets without the deleted members")
 }
 
+var recryptAfter = false
+
 // groupCmd represents the group command
 var groupCmd = &cobra.Command{
 	Use:   "group",
 	Short: "conspire group operations",
-	Long: `Operations on conspire groups, which include listing members,
-adding members, and removing members.`,
+	Long: `Operations on conspire groups, which include listing groups,
+listing members, adding members, removing members, and binding groups to
+directories of secrets.`,
+}
+
+// lsGroupCmd represents the ls command
+var lsGroupCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "list the groups in the vault",
+	Long: `List the key groups in the vault, with the number of members of each
+and any members whose keys have expired or been revoked.
+
+Example:
+
+$ conspire group ls
+
+ Group                Members Expired / Revoked
+-------------------- ------- --------------------------------------------
+default                    3 4ABEABCDEFCC123C expired
+detectives                 2
+
+`,
+	Run: lsGroups,
 }
 
 // listCmd represents the list command
@@ -55,6 +79,9 @@ var addCmd = &cobra.Command{
 	Short: "add members to a group",
 	Long: `Add the listed key ids to the members of a key group.
 
+Secrets already encrypted for the group can't be read by the new members
+until they are recrypted, either with --recrypt or with "conspire recrypt".
+
 Example:
 
 $ conspire list add default 4ABEABCDEFCC123B 4ABEABCDEFCC123C
@@ -68,6 +95,9 @@ var delCmd = &cobra.Command{
 	Short: "delete members from a group",
 	Long: `Delete the listed key ids from the members of a key group.
 
+Deleted members can still read the secrets already encrypted for the group
+until they are recrypted, either with --recrypt or with "conspire recrypt".
+
 Example:
 
 $ conspire list del default 4ABEABCDEFCC123B 4ABEABCDEFCC123C
@@ -75,34 +105,101 @@ $ conspire list del default 4ABEABCDEFCC123B 4ABEABCDEFCC123C
 	Run: delList,
 }
 
+// bindCmd represents the bind command
+var bindCmd = &cobra.Command{
+	Use:   "bind [group] [directory]",
+	Short: "encrypt the secrets in a directory for a group",
+	Long: `Bind a directory of secrets to a key group, so that secrets in it and
+below it are encrypted for that group unless another is given with
+--group. A binding in a deeper directory takes precedence. If no directory
+is given, the group is bound to the whole vault, and an empty group name
+removes the binding.
+
+Example:
+
+$ conspire group bind ops prod
+$ conspire secret edit prod/db/password
+`,
+	Run: bindGroup,
+}
+
+func lsGroups(cmd *cobra.Command, args []string) {
+
+	v := openVault()
+
+	names, err := v.Groups()
+	if err != nil {
+		exitf(err, "Couldn't list groups")
+	}
+
+	now := time.Now()
+
+	if !Terse {
+		fmt.Printf("\n")
+		fmt.Printf(" Group                Members Expired / Revoked\n")
+		fmt.Printf("-------------------- ------- --------------------------------------------\n")
+	}
+
+	for _, name := range names {
+
+		g, err := v.ReadGroup(name)
+		if err != nil {
+			exitf(err, "Couldn't read group %v", name)
+		}
+
+		// note the members that can no longer be encrypted to
+		var stale []string
+		for _, e := range g.Members {
+			if vault.KeyRevoked(e) {
+				stale = append(stale, fmt.Sprintf("%016X revoked", e.PrimaryKey.KeyId))
+			} else if vault.KeyExpired(e, now) {
+				stale = append(stale, fmt.Sprintf("%016X expired", e.PrimaryKey.KeyId))
+			}
+		}
+
+		if Terse {
+			// terse give a minimal, parseable format
+			fmt.Printf("%s;%d;%s\n", name, len(g.Members), strings.Replace(strings.Join(stale, ","), " ", ":", -1))
+			continue
+		}
+
+		if len(stale) == 0 {
+			fmt.Printf("%-20s %7d\n", name, len(g.Members))
+			continue
+		}
+		for i, s := range stale {
+			if i == 0 {
+				fmt.Printf("%-20s %7d %s\n", name, len(g.Members), s)
+			} else {
+				fmt.Printf("%-28s %s\n", "", s)
+			}
+		}
+	}
+
+	if !Terse {
+		fmt.Printf("\n")
+	}
+
+}
+
 func groupList(cmd *cobra.Command, args []string) {
 
 	// The default group is always "default"
-	group := "default"
+	group := vault.DefaultGroup
 
 	if len(args) > 0 {
 		group = args[0]
 	}
 
-	// open the group file
-	groupfile, err := os.Open(filepath.Join(VaultDir, group))
-	defer groupfile.Close()
-	if err != nil {
-		fmt.Printf("Couldn't open group file %v\n%v\n", filepath.Join(VaultDir, group), err)
-		os.Exit(-1)
-	}
-
-	// read the list
-	groupList, err := openpgp.ReadArmoredKeyRing(groupfile)
+	g, err := openVault().ReadGroup(group)
 	if err != nil {
-		fmt.Printf("Couldn't read members of group file %v\n%v\n", filepath.Join(VaultDir, group), err)
-		os.Exit(-1)
+		exitf(err, "Couldn't read members of group %v", group)
 	}
 
 	// list available keys
 	if Terse {
 		// terse give a minimal, parseable format
-		for _, e := range groupList {
+		for _, e := range g.Members {
 			fmt.Printf("%016X;%010X:%010X;", e.PrimaryKey.KeyId, e.PrimaryKey.Fingerprint[0:10], e.PrimaryKey.Fingerprint[10:20])
 
 			num := len(e.Identities) - 1
@@ -124,7 +221,7 @@ func groupList(cmd *cobra.Command, args []string) {
 		fmt.Printf(" Key Id          Key Fingerprint / Identity\n")
 		fmt.Printf("---------------- --------------------------------------------------------\n")
 
-		for _, e := range groupList {
+		for _, e := range g.Members {
 
 			fmt.Printf("%016X %010X %010X\n", e.PrimaryKey.KeyId, e.PrimaryKey.Fingerprint[0:10], e.PrimaryKey.Fingerprint[10:20])
 
@@ -142,219 +239,105 @@ func groupList(cmd *cobra.Command, args []string) {
 func addList(cmd *cobra.Command, args []string) {
 
 	if len(args) < 2 {
-		fmt.Println("You must specify a group and at least one key to add")
-		os.Exit(-1)
+		usagef("You must specify a group and at least one key to add")
 	}
 
-	// Open the public keychain for reading
-	pubfile, err := os.Open(PubRingPath)
-	defer pubfile.Close()
-	if err != nil {
-		fmt.Printf("Couldn't open public keychain file %v\n%v\n", PubRingPath, err)
-		os.Exit(-1)
-	}
-	pubList, err := openpgp.ReadKeyRing(pubfile)
-	if err != nil {
-		fmt.Printf("Couldn't read members of public keychain file %v\n%v\n", PubRingPath, err)
-		os.Exit(-1)
+	for _, keyid := range args[1:] {
+		if _, err := vault.ParseKeyId(keyid); err != nil {
+			exitf(err, "Key %v is not a valid KeyID", keyid)
+		}
 	}
 
-	groupList := openpgp.EntityList{}
+	v := openVault()
 
-	// read the named list to append to
-	group := args[0]
-	groupfile, err := os.Open(filepath.Join(VaultDir, group))
-	defer groupfile.Close()
+	added, skipped, err := v.AddMembers(args[0], args[1:]...)
 	if err != nil {
-
-		if os.IsNotExist(err) {
-			if Verbose {
-				fmt.Printf("Group file %s doesn't exist. Will create it.\n", group)
-			}
-		} else {
-			fmt.Printf("Couldn't open group file %v\n%v\n", filepath.Join(VaultDir, group), err)
-			os.Exit(-1)
-		}
-
-	} else {
-
-		groupList, err = openpgp.ReadArmoredKeyRing(groupfile)
-		if err != nil {
-			fmt.Printf("Couldn't read members of group file %v\n%v\n", filepath.Join(VaultDir, group), err)
-			os.Exit(-1)
-		}
+		exitf(err, "Couldn't add members to group %v", args[0])
 	}
 
-	// counters
-	added := 0
-	skipped := 0
+	fmt.Printf("Added %v and skipped %v\n", added, skipped)
 
-	// Start adding
-	if Verbose {
-		fmt.Printf("Adding users to group %s\n", group)
+	if added > 0 {
+		offerRecrypt(v, args[0])
 	}
 
-	for _, keyid := range args[1:] {
-
-		kid, err := hex.DecodeString(keyid)
-		if err != nil || len(kid) != 8 {
-			if Verbose {
-				fmt.Printf("Key %v is not a valid KeyID. Skipping.\n", keyid)
-			}
-			skipped += 1
-			continue
-		}
-
-		ukid := binary.BigEndian.Uint64(kid)
+}
 
-		// Is the key already in the group?
-		already_there := groupList.KeysById(ukid)
-		if len(already_there) > 0 {
-			if Verbose {
-				fmt.Printf("Key id %X is already in the group. Skipped.\n", ukid)
-			}
-			skipped += 1
-			continue
-		}
+func delList(cmd *cobra.Command, args []string) {
 
-		// Get key details from the public keychain and add
-		match := pubList.KeysById(ukid)
-		for _, m := range match {
+	if len(args) < 2 {
+		usagef("You must specify a group and at least one key to delete.")
+	}
 
-			for _, id := range m.Entity.Identities {
-				if Verbose {
-					fmt.Printf("Adding key id %X (%v)\n", ukid, id.Name)
-				}
-				break
-			}
-			groupList = append(groupList, m.Entity)
-			added += 1
+	for _, keyid := range args[1:] {
+		if _, err := vault.ParseKeyId(keyid); err != nil {
+			exitf(err, "Key %v is not a valid KeyID", keyid)
 		}
-
 	}
 
-	// explicitly close the files
-	pubfile.Close()
-	groupfile.Close()
+	v := openVault()
 
-	// re-open the group file for writing
-	fout, err := os.Create(filepath.Join(VaultDir, group))
+	deleted, skipped, err := v.RemoveMembers(args[0], args[1:]...)
 	if err != nil {
-		fmt.Printf("Couldn't open %v for writing: %v\n", filepath.Join(VaultDir, group), err)
-		os.Exit(-1)
+		exitf(err, "Couldn't delete members from group %v", args[0])
 	}
 
-	w, err := armor.Encode(fout, openpgp.PublicKeyType, nil)
-	if err != nil {
-		fmt.Printf("Couldn't armor %v: %v\n", group, err)
-		os.Exit(-1)
-	}
-	defer w.Close()
+	fmt.Printf("Deleted %v and skipped %v\n", deleted, skipped)
 
-	for _, e := range groupList {
-		e.Serialize(w)
+	if deleted > 0 {
+		offerRecrypt(v, args[0])
 	}
-	fmt.Printf("Added %v and skipped %v\n", added, skipped)
 
 }
 
-func delList(cmd *cobra.Command, args []string) {
-
-	if len(args) < 2 {
-		fmt.Println("You must specify a group and at least one key to delete.")
-		os.Exit(-1)
-	}
-
-	// read the named list to append to
-	group := args[0]
-	groupfile, err := os.Open(filepath.Join(VaultDir, group))
-	defer groupfile.Close()
-	if err != nil {
-
-		fmt.Printf("Couldn't open group file %v\n%v\n", filepath.Join(VaultDir, group), err)
-		os.Exit(-1)
-
-	}
+// offerRecrypt recrypts the secrets of a group whose members changed if
+// --recrypt was given, and otherwise says how to.
+func offerRecrypt(v *vault.Vault, group string) {
 
-	groupList, err := openpgp.ReadArmoredKeyRing(groupfile)
+	names, err := v.Secrets()
 	if err != nil {
-		fmt.Printf("Couldn't read members of group file %v\n%v\n", filepath.Join(VaultDir, group), err)
-		os.Exit(-1)
+		exitf(err, "Couldn't list secrets")
 	}
 
-	// counters
-	deleted := 0
-	skipped := 0
-
-	// Start deleting
-	if Verbose {
-		fmt.Printf("Deleting users from group %s\n", group)
+	if recryptAfter {
+		if _, failed := recryptGroup(v, group, names); failed > 0 {
+			exitWith(ExitError)
+		}
+		return
 	}
 
-	for _, keyid := range args[1:] {
-
-		kid, err := hex.DecodeString(keyid)
-		if err != nil || len(kid) != 8 {
-			if Verbose {
-				fmt.Printf("Key %v is not a valid KeyID. Skipping.\n", keyid)
-			}
-			skipped += 1
-			continue
+	count := 0
+	for _, name := range names {
+		if owner, err := v.Owner(name); err == nil && owner == group {
+			count += 1
 		}
+	}
+	if count > 0 {
+		fmt.Printf("%v secrets are still encrypted for the old members of group %v.\n", count, group)
+		fmt.Printf("Run \"conspire recrypt --group %v --all\" to recrypt them.\n", group)
+	}
 
-		ukid := binary.BigEndian.Uint64(kid)
-		found := false
-
-		// Is the key already in the group?
-		for _, e := range groupList {
-
-			if e.PrimaryKey == nil {
-				continue
-			}
-
-			if ukid == e.PrimaryKey.KeyId {
-				found = true
-				e.PrimaryKey = nil
-				deleted += 1
-				if Verbose {
-					fmt.Printf("Key id %X deleted\n", ukid)
-				}
-
-			}
+}
 
-		}
-
-		if found == false {
-			if Verbose {
-				fmt.Printf("Key id %X not found. Skipped.\n", ukid)
-			}
-			skipped += 1
-		}
+func bindGroup(cmd *cobra.Command, args []string) {
 
+	if len(args) < 1 || len(args) > 2 {
+		usagef("You must specify a group and optionally a directory")
 	}
 
-	// explicitly close the files
-	groupfile.Close()
-
-	// re-open the group file for writing
-	fout, err := os.Create(filepath.Join(VaultDir, group))
-	if err != nil {
-		fmt.Printf("Couldn't open %v for writing: %v\n", filepath.Join(VaultDir, group), err)
-		os.Exit(-1)
+	dir := "."
+	if len(args) > 1 && strings.Trim(args[1], "/") != "" {
+		dir = strings.Trim(args[1], "/")
 	}
 
-	w, err := armor.Encode(fout, openpgp.PublicKeyType, nil)
-	if err != nil {
-		fmt.Printf("Couldn't armor %v: %v\n", group, err)
-		os.Exit(-1)
+	if err := openVault().BindGroup(dir, args[0]); err != nil {
+		exitf(err, "Couldn't bind directory %v to group %v", dir, args[0])
 	}
-	defer w.Close()
 
-	for _, e := range groupList {
-		if e.PrimaryKey != nil {
-			e.Serialize(w)
-		}
+	if args[0] == "" {
+		fmt.Printf("Removed the group binding of %v\n", dir)
+	} else {
+		fmt.Printf("Secrets in %v will be encrypted for %v\n", dir, args[0])
 	}
-	fmt.Printf("Deleted %v and skipped %v\n", deleted, skipped)
 
 }
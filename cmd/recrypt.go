@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+// recryptCmd represents the recrypt command
+var recryptCmd = &cobra.Command{
+	Use:   "recrypt [secret] ...",
+	Short: "recrypt many secrets at once",
+	Long: `Recrypt the listed secrets, or all of them with --all, each for the
+group it was encrypted for. With --group, only the secrets encrypted for
+that group are recrypted, which is what you want after changing its
+members: until then, removed members can still read them.
+
+Use --dry-run to list the secrets that would be recrypted without
+touching them. Secrets that can't be recrypted are reported and skipped,
+and a summary is printed at the end.
+
+Example:
+
+$ conspire group delete ops 4ABEABCDEFCC123C
+$ conspire recrypt --group ops --all
+Recrypted 12 and failed 0
+`,
+	Run: recryptSecrets,
+}
+
+var recryptAll = false
+var recryptDryRun = false
+
+func init() {
+	RootCmd.AddCommand(recryptCmd)
+	recryptCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "only recrypt secrets encrypted for this group")
+	recryptCmd.Flags().BoolVarP(&recryptAll, "all", "a", false, "recrypt all secrets")
+	recryptCmd.Flags().BoolVarP(&recryptDryRun, "dry-run", "n", false, "list the secrets that would be recrypted")
+}
+
+func recryptSecrets(cmd *cobra.Command, args []string) {
+
+	if len(args) == 0 && !recryptAll {
+		usagef("You must specify the secrets to recrypt, or --all")
+	}
+
+	v := openVault()
+
+	names := args
+	if recryptAll {
+		all, err := v.Secrets()
+		if err != nil {
+			exitf(err, "Couldn't list secrets")
+		}
+		names = all
+	}
+
+	_, failed := recryptGroup(v, groupFlag, names)
+	if failed > 0 {
+		exitWith(ExitError)
+	}
+
+}
+
+// recryptGroup recrypts the named secrets that were encrypted for group,
+// or all of them if group is "", each for the group it was encrypted for.
+// It honours --dry-run, reports failures as it goes, prints a summary and
+// returns the number of secrets recrypted and failed.
+func recryptGroup(v *vault.Vault, group string, names []string) (recrypted, failed int) {
+
+	for _, name := range names {
+
+		owner, err := v.Owner(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't read secret %v\n%v\n", name, err)
+			failed += 1
+			continue
+		}
+		if group != "" && owner != group {
+			continue
+		}
+		if owner == "" {
+			fmt.Fprintf(os.Stderr, "Can't tell which group secret %v was encrypted for. Skipped.\nUse \"conspire secret recrypt --group\" to choose one.\n", name)
+			failed += 1
+			continue
+		}
+
+		if recryptDryRun {
+			fmt.Printf("Would recrypt %v for group %v\n", name, owner)
+			recrypted += 1
+			continue
+		}
+
+		if err := recryptOne(v, name, owner); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't recrypt secret %v\n%v\n", name, err)
+			failed += 1
+			continue
+		}
+		if Verbose {
+			fmt.Printf("Recrypted %v for group %v\n", name, owner)
+		}
+		recrypted += 1
+	}
+
+	if recryptDryRun {
+		fmt.Printf("Would recrypt %v and fail %v\n", recrypted, failed)
+	} else {
+		fmt.Printf("Recrypted %v and failed %v\n", recrypted, failed)
+	}
+
+	return recrypted, failed
+}
+
+// recryptOne recrypts the named secret for group, holding its lock.
+func recryptOne(v *vault.Vault, name, group string) error {
+
+	lock, err := v.LockSecret(name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	secret, err := v.ReadSecret(name)
+	if err != nil {
+		return err
+	}
+
+	return v.WriteSecretIf(name, group, secret.Data, secret.Version)
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render <template>",
+	Short: "fill in a template with secrets",
+	Long: `Fill in a Go text/template with secrets, for config files that need
+credentials in them, and write the result to stdout or to the file given
+with --output. A template of - is read from stdin.
+
+Templates can use
+
+  {{ secret "prod/api/token" }}       the whole secret, without trailing newlines
+  {{ field "prod/db" "username" }}    one field of a structured secret
+
+Each secret is decrypted at most once, however often it is used. Rendering
+fails, and nothing is written, if a secret or field doesn't exist. The
+--output file is replaced in one step and can only be read by you.
+
+Example:
+
+$ cat app.conf.tmpl
+[database]
+user = {{ field "prod/db" "username" }}
+password = {{ field "prod/db" "password" }}
+$ conspire render app.conf.tmpl --output app.conf
+`,
+	Run: render,
+}
+
+var outputFlag = ""
+
+func init() {
+	RootCmd.AddCommand(renderCmd)
+	renderCmd.Flags().StringVarP(&outputFlag, "output", "o", "", "write to this file, readable only by you, instead of stdout")
+}
+
+func render(cmd *cobra.Command, args []string) {
+
+	if len(args) != 1 {
+		usagef("You must specify one template to render")
+	}
+
+	var text []byte
+	var err error
+	if args[0] == "-" {
+		text, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		text, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		exitf(err, "Couldn't read template %v", args[0])
+	}
+
+	// templates are parsed before opening the vault, to report mistakes
+	// without asking for a passphrase
+	var secrets *secretCache
+	funcs := template.FuncMap{
+		"secret": func(name string) (string, error) {
+			s, err := secrets.Secret(name)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimRight(string(s.Data), "\r\n"), nil
+		},
+		"field": func(name, field string) (string, error) {
+			return secrets.Field(name, field)
+		},
+	}
+	t, err := template.New(filepath.Base(args[0])).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		usagef("Couldn't parse template %v\n%v", args[0], err)
+	}
+
+	secrets = newSecretCache(openVault())
+	out := new(bytes.Buffer)
+	if err := t.Execute(out, nil); err != nil {
+		exitf(err, "Couldn't render template %v", args[0])
+	}
+
+	if outputFlag == "" {
+		if _, err := os.Stdout.Write(out.Bytes()); err != nil {
+			exitf(err, "Couldn't write data to StdOut")
+		}
+		return
+	}
+
+	if err := writePrivate(outputFlag, out.Bytes()); err != nil {
+		exitf(err, "Couldn't write %v", outputFlag)
+	}
+}
+
+// writePrivate replaces the file at path with data, readable only by the
+// user. Readers see either the old file or the new one, never part of it.
+func writePrivate(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	atExit(func() { os.Remove(f.Name()) })
+
+	// TempFile creates the file with mode 0600
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
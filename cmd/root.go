@@ -18,8 +18,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
 	//"github.com/spf13/viper"
 )
 
@@ -31,7 +33,17 @@ var RootCmd = &cobra.Command{
 
 Conspire is a tool for managing encrypted secrets among groups. It uses
 OpenGPG keys to encrypt secrets for multiple people. It also includes
-support for managing groups of users as distinct keychains.`,
+support for managing groups of users as distinct keychains.
+
+Errors are reported on stderr. The exit status is 0 on success, 2 for
+usage errors, 3 if the secret doesn't exist, 4 if the group doesn't
+exist, 5 if the group file is corrupt, 6 if decryption failed, 7 if there
+is no private key for the secret, 8 for a malformed key id, 9 if the GPG
+agent is unavailable, 10 for a malformed secret name, 11 if the secret or
+group is locked by someone else, 12 if the secret changed while it was
+being edited, 13 if a secret to be created already exists, 14 if the
+secret isn't structured or its structure is broken, 15 if the secret has
+no such field, and 1 for any other failure.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//Run: CmdRun,
@@ -39,6 +51,7 @@ support for managing groups of users as distinct keychains.`,
 
 var SecRingPath = ""
 var PubRingPath = ""
+var PrivateKeysDir = ""
 var VaultDir = ""
 var Editor = ""
 var Terse = false
@@ -48,9 +61,17 @@ var Verbose = false
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 
-	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(-1)
+	handleSignals()
+
+	// docker runs its credential helpers by name
+	if strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == dockerHelperName {
+		RootCmd.SetArgs(append([]string{"docker-credential"}, os.Args[1:]...))
+	}
+
+	err := RootCmd.Execute()
+	runCleanups()
+	if err != nil {
+		os.Exit(ExitUsage)
 	}
 
 }
@@ -65,12 +86,10 @@ func init() {
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 
-	gpghome := os.Getenv("GNUPGHOME")
-	if gpghome == "" {
-		gpghome = filepath.Join(os.Getenv("HOME"), ".gnupg")
-	}
+	gpghome := vault.GnuPGHome()
 	SecRingPath = filepath.Join(gpghome, "secring.gpg")
-	PubRingPath = filepath.Join(gpghome, "pubring.gpg")
+	PubRingPath = vault.PublicKeyring(gpghome)
+	PrivateKeysDir = filepath.Join(gpghome, "private-keys-v1.d")
 
 	if VaultDir == "" {
 		VaultDir, _ = os.Getwd()
@@ -81,3 +100,33 @@ func initConfig() {
 	}
 
 }
+
+// openVault opens the vault in VaultDir using the configured keyrings.
+func openVault() *vault.Vault {
+
+	v, err := vault.Open(VaultDir)
+	if err != nil {
+		exitf(err, "Couldn't open vault directory %v", VaultDir)
+	}
+
+	// scrub any plaintext left behind by earlier runs
+	autoScrub(VaultDir)
+
+	v.SecRingPath = SecRingPath
+	v.PubRingPath = PubRingPath
+	v.PrivateKeysDir = PrivateKeysDir
+
+	// Let the agent decrypt with the keys it holds, if it is running
+	if c, err := NewGpgAgentConn(); err == nil {
+		v.Agent = c
+	}
+	v.Prompt = Prompt(v)
+	atExit(v.ReleaseLocks)
+	if Verbose {
+		v.Logf = func(format string, args ...interface{}) {
+			fmt.Printf(format, args...)
+		}
+	}
+
+	return v
+}
@@ -1,26 +1,30 @@
 package cmd
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
-	"path/filepath"
-
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
 
 	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
 )
 
 var editSecretCmd = &cobra.Command{
 	Use:   "edit <secret>",
 	Short: "edit the value of the secret",
 	Long: `Edit the contents of the secret stored in the vault.
-Creates a new secret if one doesn't already exist.`,
+Creates a new secret if one doesn't already exist.
+
+If the secret was a structured secret (see "secret show"), it has to
+stay one in the same format, or you are asked to edit it again.
+
+Secret names may be paths, such as prod/db/password, and directories are
+created as needed. Unless --group is given, an existing secret is encrypted
+for the group it was encrypted for before, and a new one for the group
+bound to its directory (see "conspire group bind"), or the default group.`,
 	Run: editSecret,
 }
 
@@ -28,17 +32,20 @@ var recryptSecretCmd = &cobra.Command{
 	Use:   "recrypt <secret>",
 	Short: "recrypt the value of the secret",
 	Long: `Recrypt the contents of the secret stored in the vault.
-This is useful to update a secret after you change group members.`,
+This is useful to update a secret after you change group members.
+
+The secret is encrypted for the group it was encrypted for before, unless
+--group is given, in which case a warning is printed if the group changes.`,
 	Run: recryptSecret,
 }
 
-var group string
+var groupFlag string
 
 func init() {
 	secretCmd.AddCommand(editSecretCmd)
 	secretCmd.AddCommand(recryptSecretCmd)
-	recryptSecretCmd.Flags().StringVarP(&group, "group", "g", "default", "group to whom the secret will be encrypted")
-	editSecretCmd.Flags().StringVarP(&group, "group", "g", "default", "group to whom the secret will be encrypted")
+	recryptSecretCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom the secret will be encrypted (default: the group it was encrypted for)")
+	editSecretCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom the secret will be encrypted (default: the group it was encrypted for)")
 	editSecretCmd.Flags().StringVarP(&Editor, "editor", "e", os.Getenv("EDITOR"), "editor to use")
 }
 
@@ -51,57 +58,27 @@ func recryptSecret(cmd *cobra.Command, args []string) {
 		fmt.Println("  Using vault directory: " + VaultDir)
 	}
 	if len(args) < 1 {
-		fmt.Printf("You must specify a secret to recrypt\n")
-		os.Exit(0)
+		usagef("You must specify a secret to recrypt")
 	}
 
+	v := openVault()
 	name := args[0]
-	spath := filepath.Join(VaultDir, name)
-	file, err := os.OpenFile(spath, os.O_RDWR, 0660)
-	if err != nil {
-		fmt.Printf("Couldn't open secret file %v\n%v\n", spath, err)
-		os.Exit(-1)
-	}
-
-	secret := getSecret(name)
 
-	// Create a temporary file and copy the secret in
-	base := ".tmp." + path.Base(spath)
-	tmpfile, err := ioutil.TempFile(VaultDir, base)
+	lock, err := v.LockSecret(name)
 	if err != nil {
-		fmt.Printf("Couldn't create secure temporary file in %v\n%v\n", VaultDir, err)
-		os.Exit(-1)
+		exitf(err, "Couldn't lock secret %v", name)
 	}
-	tmpname := tmpfile.Name()
+	defer lock.Unlock()
 
-	if _, err := io.Copy(tmpfile, secret); err != nil {
-		fmt.Printf("Couldn't write secret data into temp file %v\n%v\n", tmpname, err)
-		os.Exit(-1)
-	}
-	tmpfile.Close()
-
-	// Encrypt the temporary file and overwrite the previous secret
-	tmpfile, err = os.Open(tmpname)
+	secret, err := v.ReadSecret(name)
 	if err != nil {
-		fmt.Printf("Couldn't read back contents of edited buffer %v\n%v\n", tmpname, err)
-		os.Exit(-1)
-	}
-	raw := new(bytes.Buffer)
-	if _, err := raw.ReadFrom(tmpfile); err != nil {
-		fmt.Printf("Couldn't read data from %v into buffer\n%v\n", tmpname, err)
-		os.Exit(-1)
-	}
-	encrypted := encrypt(raw, group)
-	if _, err := io.Copy(file, encrypted); err != nil {
-		fmt.Printf("Couldn't write encrypted data into file %v\n%v\n", tmpname, err)
-		os.Exit(-1)
+		exitf(err, "Couldn't read secret %v", name)
 	}
 
-	// clean up
-	file.Close()
-	tmpfile.Close()
-	if os.Remove(tmpname) != nil {
-		fmt.Printf("Couldn't remove unencrypted temp file %v\nYou should remove it manually.%v\n", tmpname, err)
+	group := encryptionGroup(v, name, true, secret.Group)
+
+	if err := v.WriteSecretIf(name, group, secret.Data, secret.Version); err != nil {
+		exitf(err, "Couldn't write secret %v", name)
 	}
 
 }
@@ -118,135 +95,154 @@ func editSecret(cmd *cobra.Command, args []string) {
 	}
 
 	if len(args) < 1 {
-		fmt.Printf("You must specify a secret to edit\n")
-		os.Exit(0)
+		usagef("You must specify a secret to edit")
 	}
 
-	secret := bytes.NewBufferString("secret")
-
+	v := openVault()
 	name := args[0]
-	spath := filepath.Join(VaultDir, name)
-	file, err := os.OpenFile(spath, os.O_RDWR, 0660)
+	secret := []byte("secret")
 
+	// keep others from editing it at the same time
+	lock, err := v.LockSecret(name)
 	if err != nil {
+		exitf(err, "Couldn't lock secret %v", name)
+	}
+	defer lock.Unlock()
 
-		// if there is an error, it is likely because the file doesn't exist
-		if os.IsNotExist(err) {
-			file, err = os.Create(spath)
-			if err != nil {
-				fmt.Printf("Couldn't create secret file %v\n%v\n", spath, err)
-				os.Exit(-1)
-			}
-		} else {
-			// maybe not
-			fmt.Printf("Couldn't open secret file %v\n%v\n", spath, err)
-			os.Exit(-1)
+	// read the existing secret, if there is one
+	var existing *vault.Secret
+	version, owner := "", ""
+	if v.Exists(name) {
+		s, err := v.ReadSecret(name)
+		if err != nil {
+			exitf(err, "Couldn't read secret %v", name)
 		}
-	} else {
-		// no error opening the existing file, so read the secret
-		secret = getSecret(name)
+		secret = s.Data
+		existing = s
+		version, owner = s.Version, s.Group
 	}
-	defer file.Close()
 
-	// Create a temporary file and copy the secret in
-	base := ".tmp." + path.Base(spath)
-	tmpfile, err := ioutil.TempFile(VaultDir, base)
-	if err != nil {
-		fmt.Printf("Couldn't create secure temporary file in %v\n%v\n", VaultDir, err)
-		os.Exit(-1)
-	}
-	tmpname := tmpfile.Name()
+	group := encryptionGroup(v, name, existing != nil, owner)
 
-	if _, err := io.Copy(tmpfile, secret); err != nil {
-		fmt.Printf("Couldn't write secret data into temp file %v\n%v\n", tmpname, err)
-		os.Exit(-1)
+	// keep the structure of a structured secret
+	var format vault.Format
+	if existing != nil {
+		if r, err := existing.Record(); err == nil && r.Structured() {
+			format = r.Format
+		}
 	}
-	tmpfile.Close()
 
-	// Run the editor on the temporary file
-	c := exec.Command(Editor, tmpname)
-	c.Stdin = os.Stdin
-	c.Stdout = os.Stdout
-	err = c.Run()
+	// Stage the secret for the editor, outside the vault
+	tmpname, err := stagePlaintext(name, secret)
 	if err != nil {
-		fmt.Printf("Couldn't edit temp file %v with editor %v\n%v\n", tmpname, Editor, err)
-		os.Exit(-1)
+		exitf(err, "Couldn't create secure temporary file for secret %v", name)
 	}
+	atExit(func() { shred(tmpname) })
 
-	// Encrypt the temporary file and overwrite the previous secret
-	tmpfile, err = os.Open(tmpname)
-	if err != nil {
-		fmt.Printf("Couldn't read back contents of edited buffer %v\n%v\n", tmpname, err)
-		os.Exit(-1)
-	}
-	raw := new(bytes.Buffer)
-	if _, err := raw.ReadFrom(tmpfile); err != nil {
-		fmt.Printf("Couldn't read data from %v into buffer\n%v\n", tmpname, err)
-		os.Exit(-1)
+	var raw []byte
+	for {
+		// Run the editor on the temporary file
+		if err := runEditor(tmpname); err != nil {
+			exitf(err, "Couldn't edit temp file %v with editor %v", tmpname, Editor)
+		}
+
+		raw, err = ioutil.ReadFile(tmpname)
+		if err != nil {
+			exitf(err, "Couldn't read back contents of edited buffer %v", tmpname)
+		}
+
+		err = checkFormat(name, raw, format)
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if !confirm("Edit secret %v again?", name) {
+			exitf(err, "Your changes to secret %v were not saved.", name)
+		}
 	}
-	encrypted := encrypt(raw, group)
-	if _, err := io.Copy(file, encrypted); err != nil {
-		fmt.Printf("Couldn't write encrypted data into file %v\n%v\n", tmpname, err)
-		os.Exit(-1)
+
+	// Encrypt the temporary file and overwrite the previous secret
+
+	// refuse to overwrite changes made by someone who didn't take the lock
+	if err := v.WriteSecretIf(name, group, raw, version); err != nil {
+		if errors.Is(err, vault.ErrConflict) {
+			exitf(err, "Secret %v was changed by someone else while you were editing it.\nYour changes were not saved.", name)
+		}
+		exitf(err, "Couldn't write secret %v", name)
 	}
 
 	// clean up
-	file.Close()
-	tmpfile.Close()
-	if os.Remove(tmpname) != nil {
-		fmt.Printf("Couldn't remove unencrypted temp file %v\nYou should remove it manually.%v\n", tmpname, err)
+	if err := shred(tmpname); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't remove unencrypted temp file %v\nYou should remove it manually.\n%v\n", tmpname, err)
 	}
 
 }
 
-func encrypt(secret *bytes.Buffer, group string) (out *bytes.Buffer) {
+// runEditor runs the editor on the staged plaintext.
+func runEditor(tmpname string) error {
+	c := exec.Command(Editor, tmpname)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	ignoreInterrupts(true)
+	defer ignoreInterrupts(false)
+	if err := c.Start(); err != nil {
+		return err
+	}
+	// don't leave the editor writing plaintext if we are killed
+	atExit(func() { c.Process.Kill() })
+	return c.Wait()
+}
 
-	// open the group file
-	groupfile := filepath.Join(VaultDir, group)
-	groupKeyring, err := os.Open(groupfile)
-	defer groupKeyring.Close()
-	if err != nil {
-		fmt.Printf("Couldn't open group keyring file %v\n%v\n", groupfile, err)
-		os.Exit(-1)
+// checkFormat checks that an edited secret is still a structured secret
+// of the given format, if it was one before.
+func checkFormat(name string, data []byte, format vault.Format) error {
+	if format == 0 {
+		return nil
+	}
+	if format == vault.FormatJSON {
+		// report the syntax error rather than a change of format
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return &vault.Error{Op: "edit secret", Name: name, Kind: vault.ErrBadFormat, Err: err}
+		}
 	}
-
-	// Get the group entities
-	groupKeys, err := openpgp.ReadArmoredKeyRing(groupKeyring)
+	r, err := (&vault.Secret{Name: name, Data: data}).Record()
 	if err != nil {
-		fmt.Printf("Couldn't read group keys for %v\n%v\n", group, err)
-		os.Exit(-1)
+		return err
 	}
-
-	if Verbose {
-		// Print out the list of recipients
-		for _, e := range groupKeys {
-			fmt.Printf("Encrypting for %016X\n", e.PrimaryKey.KeyId)
-		}
+	if r.Format != format {
+		return &vault.Error{Op: "edit secret", Name: name, Kind: vault.ErrBadFormat, Err: fmt.Errorf("was a %v secret, now %v", format, r.Format)}
 	}
+	return nil
+}
 
-	// encrypt
-	encrypted := new(bytes.Buffer)
-	w, err := openpgp.Encrypt(encrypted, groupKeys, nil, nil, nil)
-	if err != nil {
-		fmt.Printf("Couldn't encrypt stream\n%v\n", err)
-		os.Exit(-1)
-	}
+// encryptionGroup returns the group to encrypt the named secret for: the
+// one given with --group, otherwise owner, the group the existing secret
+// was encrypted for, or the default group for a new secret. owner is ""
+// if the secret exists but its group can't be told. It warns when that
+// changes who can read an existing secret, or might.
+func encryptionGroup(v *vault.Vault, name string, exists bool, owner string) string {
 
-	if _, err := secret.WriteTo(w); err != nil {
-		fmt.Printf("Couldn't write secret data into encryption buffer\n%v\n", err)
-		os.Exit(-1)
+	if !exists {
+		if groupFlag != "" {
+			return groupFlag
+		}
+		return v.SecretGroup(name)
 	}
-	w.Close()
 
-	// armor encoding
-	out = new(bytes.Buffer)
-	armored, err := armor.Encode(out, "PGP MESSAGE", nil)
-	if _, err := io.Copy(armored, encrypted); err != nil {
-		fmt.Printf("Couldn't armor data into encryption buffer\n%v\n", err)
-		os.Exit(-1)
+	if owner == "" {
+		g := groupFlag
+		if g == "" {
+			g = v.SecretGroup(name)
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: Can't tell which group secret %v was encrypted for.\nIt will now be encrypted for group %v.\n", name, g)
+		return g
 	}
-	armored.Close()
 
-	return
+	if groupFlag != "" && groupFlag != owner {
+		fmt.Fprintf(os.Stderr, "WARNING: Secret %v was encrypted for group %v.\nIt will now be encrypted for group %v instead.\n", name, owner, groupFlag)
+		return groupFlag
+	}
 
+	return owner
 }
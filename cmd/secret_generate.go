@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var generateSecretCmd = &cobra.Command{
+	Use:   "generate <secret>",
+	Short: "generate a random password or passphrase as a secret",
+	Long: `Generate a random password or passphrase and store it as a secret. The
+password is only ever held in memory and written to the vault encrypted;
+it is printed as well if --show is given.
+
+By default a password of --length characters is made from the character
+classes given with --classes (lower, upper, digit and symbol), with at
+least one character from each. --no-ambiguous leaves out characters that
+are easily mistaken for each other, such as 0 and O or 1 and l.
+
+With --words, a diceware passphrase of that many words is made instead,
+from the EFF large wordlist, joined with --separator.
+
+With --pattern, the password follows a pattern, where
+
+  l  is a lower case letter     u  is an upper case letter
+  d  is a digit                 s  is a symbol
+  a  is a letter or digit       x  is any of those
+  w  is a word from the wordlist
+  \c is the character c itself, so \{ is a {
+  {n} repeats what comes before it n times
+
+and any other character stands for itself. Generated secrets are at most
+1024 characters long.
+
+An existing secret is only replaced if --force is given, and the group is
+chosen as for "secret put".
+
+Examples:
+
+$ conspire secret generate --length 32 --classes lower,digit prod/db/password
+$ conspire secret generate --words 6 --separator ' ' ops/vpn/passphrase
+$ conspire secret generate --pattern 'u{4}-d{4}-l{4}' prod/api/pin
+`,
+	Run: generateSecret,
+}
+
+var lengthFlag = 24
+var classesFlag = "lower,upper,digit,symbol"
+var noAmbiguousFlag = false
+var wordsFlag = 0
+var separatorFlag = "-"
+var patternFlag = ""
+var showFlag = false
+
+func init() {
+	secretCmd.AddCommand(generateSecretCmd)
+	generateSecretCmd.Flags().IntVarP(&lengthFlag, "length", "l", lengthFlag, "number of characters in the password")
+	generateSecretCmd.Flags().StringVarP(&classesFlag, "classes", "c", classesFlag, "character classes to use, separated by commas")
+	generateSecretCmd.Flags().BoolVarP(&noAmbiguousFlag, "no-ambiguous", "A", false, "leave out characters that are easily mistaken for each other")
+	generateSecretCmd.Flags().IntVarP(&wordsFlag, "words", "w", 0, "make a passphrase of this many words instead")
+	generateSecretCmd.Flags().StringVarP(&separatorFlag, "separator", "s", separatorFlag, "what to put between the words of a passphrase")
+	generateSecretCmd.Flags().StringVarP(&patternFlag, "pattern", "p", "", "make a password that follows this pattern instead")
+	generateSecretCmd.Flags().BoolVar(&showFlag, "show", false, "print the generated secret")
+	generateSecretCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom the secret will be encrypted (default: the group it was encrypted for)")
+	generateSecretCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace an existing secret")
+}
+
+// Character classes for generated passwords.
+var charClasses = map[string]string{
+	"lower":  "abcdefghijklmnopqrstuvwxyz",
+	"upper":  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digit":  "0123456789",
+	"symbol": "!#$%&()*+,-./:;<=>?@[]^_{|}~",
+}
+
+// ambiguous characters are left out with --no-ambiguous.
+const ambiguous = "B8G6I1l0OQDS5Z2|.,:;"
+
+// patternClasses maps the letters of a pattern to the classes they stand
+// for.
+var patternClasses = map[rune][]string{
+	'l': {"lower"},
+	'u': {"upper"},
+	'd': {"digit"},
+	's': {"symbol"},
+	'a': {"lower", "upper", "digit"},
+	'x': {"lower", "upper", "digit", "symbol"},
+}
+
+func generateSecret(cmd *cobra.Command, args []string) {
+
+	if len(args) < 1 {
+		usagef("You must specify a secret to generate")
+	}
+
+	var password string
+	var err error
+	switch {
+	case patternFlag != "":
+		password, err = generatePattern(patternFlag)
+	case wordsFlag > 0:
+		password, err = generatePassphrase(wordsFlag, separatorFlag)
+	default:
+		password, err = generatePassword(lengthFlag, strings.Split(classesFlag, ","))
+	}
+	if err != nil {
+		usagef("%v", err)
+	}
+
+	v := openVault()
+	name := args[0]
+
+	lock, err := v.LockSecret(name)
+	if err != nil {
+		exitf(err, "Couldn't lock secret %v", name)
+	}
+	defer lock.Unlock()
+
+	if v.Exists(name) && !forceFlag {
+		exitf(&vault.Error{Op: "generate secret", Name: name, Kind: vault.ErrExists}, "Secret %v already exists, use --force to replace it", name)
+	}
+
+	group, err := putGroup(v, name)
+	if err != nil {
+		exitf(err, "Couldn't tell which group secret %v is encrypted for", name)
+	}
+
+	if err := v.WriteSecret(name, group, []byte(password)); err != nil {
+		exitf(err, "Couldn't write secret %v", name)
+	}
+
+	if showFlag {
+		fmt.Println(password)
+	}
+	if Verbose {
+		fmt.Fprintf(os.Stderr, "Generated secret %v for group %v\n", name, group)
+	}
+
+}
+
+// classChars returns the characters of the named classes, less the
+// ambiguous ones if --no-ambiguous was given.
+func classChars(classes ...string) (string, error) {
+	chars := ""
+	for _, c := range classes {
+		cc, ok := charClasses[strings.TrimSpace(c)]
+		if !ok {
+			return "", fmt.Errorf("Unknown character class %q, use lower, upper, digit or symbol", c)
+		}
+		chars += cc
+	}
+	if noAmbiguousFlag {
+		chars = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(ambiguous, r) {
+				return -1
+			}
+			return r
+		}, chars)
+	}
+	return chars, nil
+}
+
+// randInt returns a uniformly random number in [0, n) from crypto/rand.
+func randInt(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		exitf(err, "Couldn't read random numbers")
+	}
+	return int(i.Int64())
+}
+
+// maxGenerated is the most characters a generated secret may have, far
+// more than any password needs, so that a slip such as x{99999999} fails
+// rather than filling memory.
+const maxGenerated = 1024
+
+// generatePassword returns a random password of length characters, with
+// at least one from each class.
+func generatePassword(length int, classes []string) (string, error) {
+	if length > maxGenerated {
+		return "", fmt.Errorf("Passwords can be at most %v characters long", maxGenerated)
+	}
+	if len(classes) > length {
+		return "", fmt.Errorf("A password of %v characters can't use all of %v character classes", length, len(classes))
+	}
+
+	all, err := classChars(classes...)
+	if err != nil {
+		return "", err
+	}
+
+	password := make([]byte, 0, length)
+	for _, c := range classes {
+		chars, _ := classChars(c)
+		password = append(password, chars[randInt(len(chars))])
+	}
+	for len(password) < length {
+		password = append(password, all[randInt(len(all))])
+	}
+
+	// shuffle, so the first characters aren't in class order
+	for i := len(password) - 1; i > 0; i-- {
+		j := randInt(i + 1)
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+// generatePassphrase returns a passphrase of n random words from the
+// wordlist.
+func generatePassphrase(n int, separator string) (string, error) {
+	if n > maxGenerated {
+		return "", fmt.Errorf("Passphrases can be at most %v characters long", maxGenerated)
+	}
+	words := make([]string, n)
+	for i := range words {
+		words[i] = wordlist[randInt(len(wordlist))]
+	}
+	phrase := strings.Join(words, separator)
+	if utf8.RuneCountInString(phrase) > maxGenerated {
+		return "", fmt.Errorf("Passphrases can be at most %v characters long", maxGenerated)
+	}
+	return phrase, nil
+}
+
+// generatePattern returns a random password following pattern, as
+// described for "secret generate".
+func generatePattern(pattern string) (string, error) {
+	var out []string
+	length := 0
+	p := []rune(pattern)
+	for i := 0; i < len(p); i++ {
+		r := p[i]
+		var gen func() string
+
+		switch {
+		case r == '\\':
+			if i+1 == len(p) {
+				return "", fmt.Errorf("Pattern %q ends with a lone \\", pattern)
+			}
+			i++
+			lit := string(p[i])
+			gen = func() string { return lit }
+		case r == 'w':
+			gen = func() string { return wordlist[randInt(len(wordlist))] }
+		case r == '{':
+			return "", fmt.Errorf("Pattern %q repeats nothing, use \\{ for a {", pattern)
+		case patternClasses[r] != nil:
+			chars, err := classChars(patternClasses[r]...)
+			if err != nil {
+				return "", err
+			}
+			gen = func() string { return string(chars[randInt(len(chars))]) }
+		default:
+			lit := string(r)
+			gen = func() string { return lit }
+		}
+
+		n := 1
+		if i+1 < len(p) && p[i+1] == '{' {
+			end := strings.IndexRune(string(p[i+1:]), '}')
+			if end < 0 {
+				return "", fmt.Errorf("Pattern %q has an unclosed {", pattern)
+			}
+			body := string(p[i+1:])[1:end]
+			var err error
+			n, err = strconv.Atoi(body)
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("Pattern %q has a bad repeat {%v}", pattern, body)
+			}
+			if n > maxGenerated {
+				return "", fmt.Errorf("Pattern %q repeats {%v} times, more than the %v characters a secret can have", pattern, body, maxGenerated)
+			}
+			i += len([]rune(body)) + 2
+		}
+		for ; n > 0; n-- {
+			s := gen()
+			if length += utf8.RuneCountInString(s); length > maxGenerated {
+				return "", fmt.Errorf("Pattern %q makes a secret longer than %v characters", pattern, maxGenerated)
+			}
+			out = append(out, s)
+		}
+	}
+	return strings.Join(out, ""), nil
+}
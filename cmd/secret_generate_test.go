@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGeneratePatternLimits(t *testing.T) {
+	tests := []struct {
+		pattern string
+		length  int // -1 if the pattern must be refused
+	}{
+		{"u{4}-d{4}", 9},
+		{"x{1024}", 1024},
+		{"x{1025}", -1},
+		{"x{999999999}", -1},
+		{"x{99999999999999999999}", -1},
+		{"x{1000}x{1000}", -1},
+		{"w{1000}", -1},
+	}
+
+	for _, tt := range tests {
+		s, err := generatePattern(tt.pattern)
+		switch {
+		case tt.length < 0 && err == nil:
+			t.Errorf("%q: made %d characters, want an error", tt.pattern, utf8.RuneCountInString(s))
+		case tt.length >= 0 && err != nil:
+			t.Errorf("%q: %v", tt.pattern, err)
+		case tt.length >= 0 && utf8.RuneCountInString(s) != tt.length:
+			t.Errorf("%q: made %d characters, want %d", tt.pattern, utf8.RuneCountInString(s), tt.length)
+		}
+	}
+
+	if _, err := generatePassword(maxGenerated+1, []string{"lower"}); err == nil {
+		t.Errorf("generatePassword made a password over the limit")
+	}
+	if _, err := generatePassphrase(999999999, " "); err == nil {
+		t.Errorf("generatePassphrase made a passphrase over the limit")
+	}
+}
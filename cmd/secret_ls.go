@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var lsSecretCmd = &cobra.Command{
+	Use:   "ls [pattern]",
+	Short: "list the secrets in the vault",
+	Long: `List the secrets in the vault with their size, when they were last
+changed, the group they are encrypted for and how many keys can read them.
+Nothing is decrypted, so no passphrase is needed.
+
+The pattern may be a directory, such as prod, to list the secrets under it,
+or a glob matched against the whole name, such as prod/*/password. A glob
+without a slash is matched against the last element of the name too, so
+'*password' finds every password.
+
+Use --tree to show nested secrets as a tree, --json for JSON, or --terse
+for one line per secret.
+
+Example:
+
+$ conspire secret ls prod
+
+ Secret                         Size Modified         Group                Keys
+------------------------------ ----- ---------------- -------------------- ----
+prod/api/token                  1231 2016-03-14 10:12 ops                     3
+prod/db/password                1187 2016-03-13 21:40 ops                     3
+
+`,
+	Run: lsSecrets,
+}
+
+var lsTree = false
+var lsJSON = false
+
+func init() {
+	secretCmd.AddCommand(lsSecretCmd)
+	lsSecretCmd.Flags().BoolVar(&lsTree, "tree", false, "show nested secrets as a tree")
+	lsSecretCmd.Flags().BoolVarP(&lsJSON, "json", "j", false, "JSON output")
+}
+
+// matchSecret reports whether the secret name is selected by pattern.
+func matchSecret(pattern, name string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if pattern == "" || strings.HasPrefix(name, pattern+"/") {
+		return true
+	}
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return false
+}
+
+type lsEntry struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Modified   time.Time `json:"modified"`
+	Group      string    `json:"group"`
+	Recipients int       `json:"recipients"`
+}
+
+func lsSecrets(cmd *cobra.Command, args []string) {
+
+	if len(args) > 1 {
+		usagef("You may specify at most one pattern")
+	}
+	pattern := ""
+	if len(args) > 0 {
+		pattern = args[0]
+		if _, err := path.Match(pattern, ""); err != nil {
+			usagef("Bad pattern %v: %v", pattern, err)
+		}
+	}
+
+	v := openVault()
+
+	names, err := v.Secrets()
+	if err != nil {
+		exitf(err, "Couldn't list secrets")
+	}
+
+	var secrets []*vault.SecretInfo
+	for _, name := range names {
+		if !matchSecret(pattern, name) {
+			continue
+		}
+		info, err := v.StatSecret(name)
+		if err != nil {
+			exitf(err, "Couldn't read secret %v", name)
+		}
+		secrets = append(secrets, info)
+	}
+
+	switch {
+	case lsJSON:
+		entries := []lsEntry{}
+		for _, s := range secrets {
+			entries = append(entries, lsEntry{s.Name, s.Size, s.Modified.UTC(), s.Group, s.Recipients})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			exitf(err, "Couldn't write secret list")
+		}
+
+	case Terse:
+		// terse give a minimal, parseable format
+		for _, s := range secrets {
+			fmt.Printf("%s;%d;%s;%s;%d\n", s.Name, s.Size, s.Modified.UTC().Format(time.RFC3339), s.Group, s.Recipients)
+		}
+
+	case lsTree:
+		printTree(secrets)
+
+	default:
+		fmt.Printf("\n")
+		fmt.Printf(" Secret                         Size Modified         Group                Keys\n")
+		fmt.Printf("------------------------------ ----- ---------------- -------------------- ----\n")
+		for _, s := range secrets {
+			fmt.Printf("%-30s %5d %s %-20s %4d\n", s.Name, s.Size, s.Modified.Local().Format("2006-01-02 15:04"), s.Group, s.Recipients)
+		}
+		fmt.Printf("\n")
+	}
+
+}
+
+// printTree prints the secrets, which are in order, as a tree of their
+// directories.
+func printTree(secrets []*vault.SecretInfo) {
+
+	fmt.Printf(".\n")
+
+	var prev []string
+	for i, s := range secrets {
+		elems := strings.Split(s.Name, "/")
+
+		// skip the directories already printed
+		common := 0
+		for common < len(prev)-1 && common < len(elems)-1 && prev[common] == elems[common] {
+			common += 1
+		}
+
+		for depth := common; depth < len(elems); depth++ {
+			prefix := ""
+			for d := 0; d < depth; d++ {
+				if lastAt(secrets, i, elems, d) {
+					prefix += "    "
+				} else {
+					prefix += "│   "
+				}
+			}
+			branch := "├── "
+			if lastAt(secrets, i, elems, depth) {
+				branch = "└── "
+			}
+
+			if depth < len(elems)-1 {
+				fmt.Printf("%s%s%s/\n", prefix, branch, elems[depth])
+			} else {
+				fmt.Printf("%s%s%s  (%s, %d keys)\n", prefix, branch, elems[depth], s.Group, s.Recipients)
+			}
+		}
+		prev = elems
+	}
+
+}
+
+// lastAt reports whether the element at depth of the i'th secret's name
+// is the last entry of its directory, that is whether no later secret
+// shares the directory it is in.
+func lastAt(secrets []*vault.SecretInfo, i int, elems []string, depth int) bool {
+	dir := strings.Join(elems[:depth], "/")
+	for _, s := range secrets[i+1:] {
+		later := strings.Split(s.Name, "/")
+		if len(later) <= depth {
+			continue
+		}
+		if strings.Join(later[:depth], "/") != dir {
+			return true
+		}
+		if later[depth] != elems[depth] {
+			return false
+		}
+	}
+	return true
+}
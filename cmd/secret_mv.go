@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var mvSecretCmd = &cobra.Command{
+	Use:   "mv <secret> <new name>",
+	Short: "rename a secret",
+	Long: `Rename a secret, along with its metadata. If the new name ends in a
+slash, the secret is moved into that directory. The secret stays encrypted
+for the same group; use "secret recrypt --group" afterwards to change it.
+An existing secret is only replaced if --force is given.`,
+	Run: mvSecret,
+}
+
+var cpSecretCmd = &cobra.Command{
+	Use:   "cp <secret> <new name>",
+	Short: "copy a secret",
+	Long: `Copy a secret. If the new name ends in a slash, the copy goes into that
+directory. The copy is encrypted for the same group as the original, in
+which case nothing needs to be decrypted, unless --group is given. An
+existing secret is only replaced if --force is given.
+
+Example:
+
+$ conspire secret cp --group contractors prod/api/token shared/api/token
+`,
+	Run: cpSecret,
+}
+
+func init() {
+	secretCmd.AddCommand(mvSecretCmd)
+	secretCmd.AddCommand(cpSecretCmd)
+	mvSecretCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace an existing secret")
+	cpSecretCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace an existing secret")
+	cpSecretCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom the copy will be encrypted (default: the group of the original)")
+}
+
+// destination returns the name a secret gets when moved or copied to, which
+// may be a directory ending in a slash.
+func destination(from, to string) string {
+	if strings.HasSuffix(to, "/") {
+		return path.Join(to, path.Base(from))
+	}
+	return to
+}
+
+// noteBinding points out when a secret ends up in a directory bound to a
+// group other than the one it is encrypted for.
+func noteBinding(v *vault.Vault, name string) {
+	owner, err := v.Owner(name)
+	if err != nil || owner == "" {
+		return
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if bound := v.DirGroup(dir); bound != "" {
+			if bound != owner {
+				fmt.Fprintf(os.Stderr, "Note: %v is encrypted for group %v, but %v is bound to group %v.\n", name, owner, dir, bound)
+			}
+			return
+		}
+		if dir == "." {
+			return
+		}
+	}
+}
+
+func mvSecret(cmd *cobra.Command, args []string) {
+
+	if len(args) != 2 {
+		usagef("You must specify a secret and its new name")
+	}
+
+	v := openVault()
+	to := destination(args[0], args[1])
+
+	if err := v.MoveSecret(args[0], to, forceFlag); err != nil {
+		exitf(err, "Couldn't move secret %v to %v", args[0], to)
+	}
+
+	noteBinding(v, to)
+
+}
+
+func cpSecret(cmd *cobra.Command, args []string) {
+
+	if len(args) != 2 {
+		usagef("You must specify a secret and the name of the copy")
+	}
+
+	v := openVault()
+	to := destination(args[0], args[1])
+
+	if err := v.CopySecret(args[0], to, groupFlag, forceFlag); err != nil {
+		exitf(err, "Couldn't copy secret %v to %v", args[0], to)
+	}
+
+	noteBinding(v, to)
+
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var putSecretCmd = &cobra.Command{
+	Use:   "put <secret>",
+	Short: "store a secret read from stdin or a file",
+	Long: `Store a secret without running an editor, reading its contents from
+standard input, or from the file given with --file. The contents are stored
+exactly as read, so they may be binary. An existing secret is only replaced
+if --force is given.
+
+Unless --group is given, a replaced secret is encrypted for the group it
+was encrypted for before, and a new one for the group bound to its
+directory (see "conspire group bind"), or the default group.
+
+Example:
+
+$ openssl rand 32 | conspire secret put prod/session/key
+`,
+	Run: putSecret,
+}
+
+var fileFlag string
+
+func init() {
+	secretCmd.AddCommand(putSecretCmd)
+	putSecretCmd.Flags().StringVarP(&fileFlag, "file", "F", "", "read the secret from this file instead of stdin")
+	putSecretCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom the secret will be encrypted (default: the group it was encrypted for)")
+	putSecretCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace an existing secret")
+}
+
+func putSecret(cmd *cobra.Command, args []string) {
+
+	if len(args) < 1 {
+		usagef("You must specify a secret to put")
+	}
+
+	v := openVault()
+	name := args[0]
+
+	lock, err := v.LockSecret(name)
+	if err != nil {
+		exitf(err, "Couldn't lock secret %v", name)
+	}
+	defer lock.Unlock()
+
+	if v.Exists(name) && !forceFlag {
+		exitf(&vault.Error{Op: "put secret", Name: name, Kind: vault.ErrExists}, "Secret %v already exists, use --force to replace it", name)
+	}
+
+	var data []byte
+	if fileFlag != "" {
+		data, err = ioutil.ReadFile(fileFlag)
+	} else {
+		data, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		exitf(err, "Couldn't read secret %v", name)
+	}
+
+	group, err := putGroup(v, name)
+	if err != nil {
+		exitf(err, "Couldn't tell which group secret %v is encrypted for", name)
+	}
+
+	if err := v.WriteSecret(name, group, data); err != nil {
+		exitf(err, "Couldn't write secret %v", name)
+	}
+
+	if Verbose {
+		fmt.Printf("Stored %v bytes as secret %v for group %v\n", len(data), name, group)
+	}
+
+}
+
+// putGroup returns the group to encrypt a secret for, as encryptionGroup
+// does, without decrypting it first.
+func putGroup(v *vault.Vault, name string) (string, error) {
+
+	if !v.Exists(name) {
+		return encryptionGroup(v, name, false, ""), nil
+	}
+
+	owner, err := v.Owner(name)
+	if err != nil && !errors.Is(err, vault.ErrNotFound) {
+		return "", err
+	}
+	return encryptionGroup(v, name, true, owner), nil
+}
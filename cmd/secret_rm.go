@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var rmSecretCmd = &cobra.Command{
+	Use:   "rm <secret> ...",
+	Short: "remove secrets",
+	Long: `Remove secrets, and their metadata, from the vault. You are asked to
+confirm each one unless --force is given. With --shred, the encrypted
+secret is overwritten before it is removed, although that can't remove
+copies held elsewhere, such as in version control.`,
+	Run: rmSecrets,
+}
+
+var forceFlag = false
+var shredFlag = false
+
+func init() {
+	secretCmd.AddCommand(rmSecretCmd)
+	rmSecretCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "don't ask for confirmation")
+	rmSecretCmd.Flags().BoolVarP(&shredFlag, "shred", "s", false, "overwrite the secret before removing it")
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes or no question on the terminal, and reports whether
+// the answer was yes.
+func confirm(format string, args ...interface{}) bool {
+	fmt.Fprintf(os.Stderr, format+" [y/N] ", args...)
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func rmSecrets(cmd *cobra.Command, args []string) {
+
+	if len(args) < 1 {
+		usagef("You must specify a secret to remove")
+	}
+
+	v := openVault()
+
+	for _, name := range args {
+		if !v.Exists(name) {
+			exitf(&vault.Error{Op: "remove secret", Name: name, Kind: vault.ErrNotFound}, "Couldn't remove secret %v", name)
+		}
+	}
+
+	removed, skipped := 0, 0
+	for _, name := range args {
+
+		if !forceFlag && !confirm("Remove secret %v?", name) {
+			skipped += 1
+			continue
+		}
+
+		if err := v.RemoveSecret(name, shredFlag); err != nil {
+			exitf(err, "Couldn't remove secret %v", name)
+		}
+		removed += 1
+	}
+
+	if Verbose || len(args) > 1 {
+		fmt.Printf("Removed %v and skipped %v\n", removed, skipped)
+	}
+
+}
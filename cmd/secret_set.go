@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+var setSecretCmd = &cobra.Command{
+	Use:   "set <secret> <field>=<value> ...",
+	Short: "set fields of a structured secret",
+	Long: `Set one or more fields of a structured secret without opening an editor,
+keeping the rest of the secret as it is. Fields that don't exist yet are
+added at the end, and a secret that doesn't exist yet is created as a
+password line followed by "key: value" lines. The password field of such a
+secret is its first line.
+
+Arguments can be seen by other users on the same machine, so use "secret
+edit" or "secret generate" for the password itself.
+
+Example:
+
+$ conspire secret set prod/db username=app url=db.example.com:5432
+`,
+	Run: setSecret,
+}
+
+func init() {
+	secretCmd.AddCommand(setSecretCmd)
+	setSecretCmd.Flags().StringVarP(&groupFlag, "group", "g", "", "group to whom the secret will be encrypted (default: the group it was encrypted for)")
+}
+
+func setSecret(cmd *cobra.Command, args []string) {
+
+	if len(args) < 2 {
+		usagef("You must specify a secret and the fields to set")
+	}
+	for _, arg := range args[1:] {
+		if !strings.Contains(arg, "=") {
+			usagef("Fields must be given as <field>=<value>, not %v", arg)
+		}
+	}
+
+	v := openVault()
+	name := args[0]
+
+	lock, err := v.LockSecret(name)
+	if err != nil {
+		exitf(err, "Couldn't lock secret %v", name)
+	}
+	defer lock.Unlock()
+
+	var existing *vault.Secret
+	version, owner := "", ""
+	r := vault.NewRecord(name)
+	if v.Exists(name) {
+		existing, err = v.ReadSecret(name)
+		if err != nil {
+			exitf(err, "Couldn't read secret %v", name)
+		}
+		version, owner = existing.Version, existing.Group
+		r, err = existing.Record()
+		if err != nil {
+			exitf(err, "Secret %v isn't a structured secret", name)
+		}
+	}
+
+	for _, arg := range args[1:] {
+		field := strings.SplitN(arg, "=", 2)
+		if err := r.Set(field[0], field[1]); err != nil {
+			exitf(err, "Couldn't set field %v of secret %v", field[0], name)
+		}
+	}
+
+	data, err := r.Bytes()
+	if err != nil {
+		exitf(err, "Couldn't write secret %v", name)
+	}
+
+	group := encryptionGroup(v, name, existing != nil, owner)
+
+	if err := v.WriteSecretIf(name, group, data, version); err != nil {
+		if errors.Is(err, vault.ErrConflict) {
+			exitf(err, "Secret %v was changed by someone else.\nYour changes were not saved.", name)
+		}
+		exitf(err, "Couldn't write secret %v", name)
+	}
+
+}
@@ -1,41 +1,42 @@
 package cmd
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
 
 	"github.com/howeyc/gopass"
 	"github.com/spf13/cobra"
+	"github.com/zoidbergconspiracy/conspire/vault"
 )
 
 var showSecretCmd = &cobra.Command{
 	Use:   "show <secret>",
 	Short: "show the value of the secret",
-	Long:  "Show the contents of the secret stored in the vault.",
-	Run:   showSecret,
+	Long: `Show the contents of the secret stored in the vault.
+
+With --field, only the named field of a structured secret is shown. A
+structured secret is either a password on the first line followed by
+"key: value" lines, a YAML mapping starting with a "---" line, or a JSON
+object. The password field of the first kind is its first line.`,
+	Run: showSecret,
 }
 
-func Prompt() func(keys []openpgp.Key, symmetric bool) (pass []byte, err error) {
+// Prompt returns a function that asks for the passphrase to unlock a
+// private key, either through the GPG Agent or on the terminal. A wrong
+// passphrase may be retried up to three times.
+func Prompt(v *vault.Vault) func(keys []openpgp.Key, symmetric bool) (pass []byte, err error) {
 	pass_tries := 3
+	errTries := errors.New("No valid passphrase after 3 tries")
 
-	if os.Getenv("GPG_AGENT_INFO") != "" {
+	if c, ok := v.Agent.(*Conn); ok {
 		// Use the GPG Agent to get the passphrase
+		wrong := ""
 		return func(keys []openpgp.Key, symmetric bool) (pass []byte, err error) {
 
-			c, err1 := NewGpgAgentConn()
-			if err1 != nil {
-				fmt.Printf("Couldn't open GpgAgent even though GPG_AGENT_INFO is set\n%v\n", err)
-				os.Exit(-1)
-			}
-
 			for _, key := range keys {
 
 				names := make([]string, len(key.Entity.Identities))
@@ -48,35 +49,40 @@ func Prompt() func(keys []openpgp.Key, symmetric bool) (pass []byte, err error)
 				keyid := fmt.Sprintf("%016X", key.PublicKey.KeyId)
 				pr := &PassphraseRequest{
 					keyid,
-					"",
+					wrong,
 					"Passphrase:",
 					fmt.Sprintf("You need a passphrase to unlock the secret key for \"%s\" (%s)", strings.Join(names, " "), keyid),
 					false,
 				}
 
-				spass, _ := c.GetPassphrase(pr)
-				pass = []byte(spass)
-				err = key.PrivateKey.Decrypt(pass)
+				spass, err := c.GetPassphrase(pr)
 				if err != nil {
+					return nil, err
+				}
+				pass = []byte(spass)
+				if v.Unlock(key, pass) != nil {
 					c.RemoveFromCache(keyid)
 					pass_tries--
 					if pass_tries < 1 {
-						fmt.Printf("No valid passphrase after 3 tries. Quitting.\n")
-						os.Exit(-1)
+						return nil, errTries
 					}
-					pr.Error = "Wrong passphrase. Please try again."
+					wrong = "Wrong passphrase. Please try again."
 				} else {
-					err = nil
-					return
+					return pass, nil
 				}
 			}
-			err = errors.New("No matching key")
-			return
+
+			if wrong != "" {
+				// ask again
+				return nil, nil
+			}
+			return nil, vault.ErrNoKey
 		}
 
 	} else {
 
 		// Just use a simple passphrase grabber
+		wrong := false
 		return func(keys []openpgp.Key, symmetric bool) (pass []byte, err error) {
 
 			for _, key := range keys {
@@ -89,80 +95,30 @@ func Prompt() func(keys []openpgp.Key, symmetric bool) (pass []byte, err error)
 
 				keyid := fmt.Sprintf("%016X", key.PublicKey.KeyId)
 
-				fmt.Printf("Enter passphrase for \"%s\" (%s): ", strings.Join(names, " "), keyid)
+				fmt.Fprintf(os.Stderr, "Enter passphrase for \"%s\" (%s): ", strings.Join(names, " "), keyid)
 				pass, err = gopass.GetPasswd()
 				if err != nil {
-					fmt.Printf("Error reading passphrase.\n%v\n", err)
-					os.Exit(-1)
+					return nil, err
 				}
-				err = key.PrivateKey.Decrypt(pass)
-				if err != nil {
+				if v.Unlock(key, pass) != nil {
 					pass_tries--
 					if pass_tries < 1 {
-						fmt.Printf("No valid passphrase after 3 tries. Quitting.\n")
-						os.Exit(-1)
+						return nil, errTries
 					}
-					fmt.Println("Wrong passphrase. Please try again.")
+					fmt.Fprintln(os.Stderr, "Wrong passphrase. Please try again.")
+					wrong = true
 				} else {
-					err = nil
-					return
+					return pass, nil
 				}
 			}
 
-			err = errors.New("No matching key")
-			return
-		}
-	}
-}
-
-func getSecret(name string) (data *bytes.Buffer) {
-	// Open the private key file
-	keyringFile, err := os.Open(SecRingPath)
-	defer keyringFile.Close()
-	if err != nil {
-		fmt.Printf("Couldn't open secret keyring file %v\n%v\n", SecRingPath, err)
-		os.Exit(-1)
-	}
-
-	entityList, err := openpgp.ReadKeyRing(keyringFile)
-	if err != nil {
-		fmt.Printf("Couldn't read secret keyring file %v\n%v\n", SecRingPath, err)
-		os.Exit(-1)
-	}
-
-	path := filepath.Join(VaultDir, name)
-	file, err := os.Open(path)
-	if err != nil {
-		fmt.Printf("Couldn't read secret file %v\n%v\n", path, err)
-		os.Exit(-1)
-	}
-
-	block, err := armor.Decode(file)
-	if err != nil {
-		fmt.Printf("Couldn't decode file %v\n%v\n", name, err)
-		os.Exit(-1)
-	}
-
-	md, err := openpgp.ReadMessage(block.Body, entityList, Prompt(), nil)
-	if err != nil {
-		fmt.Printf("Couldn't decrypt secret %v\n%v\n", name, err)
-		os.Exit(-1)
-	}
-
-	if Verbose {
-		for _, k := range md.EncryptedToKeyIds {
-			fmt.Printf("Secret encrypted for %X\n", k)
+			if wrong {
+				// ask again
+				return nil, nil
+			}
+			return nil, vault.ErrNoKey
 		}
 	}
-
-	data = bytes.NewBuffer(nil)
-	if _, err := io.Copy(data, md.UnverifiedBody); err != nil {
-		fmt.Printf("Couldn't read unencrypted data from %v\n%v\n", name, err)
-		os.Exit(-1)
-	}
-
-	return
-
 }
 
 func showSecret(cmd *cobra.Command, args []string) {
@@ -176,20 +132,34 @@ func showSecret(cmd *cobra.Command, args []string) {
 	}
 
 	if len(args) < 1 {
-		fmt.Printf("You must specify a secret to show\n")
-		os.Exit(0)
+		usagef("You must specify a secret to show")
 	}
 
-	secret := getSecret(args[0])
+	secret, err := openVault().ReadSecret(args[0])
+	if err != nil {
+		exitf(err, "Couldn't read secret %v", args[0])
+	}
 
 	if Verbose {
+		for _, k := range secret.EncryptedTo {
+			fmt.Printf("Secret encrypted for %X\n", k)
+		}
 		fmt.Println()
 		fmt.Println("-----BEGIN UNENCRYPTED SECRET----")
 	}
 
-	if _, err := io.Copy(os.Stdout, secret); err != nil {
-		fmt.Printf("Couldn't write data to StdOut\n%v\n", err)
-		os.Exit(-1)
+	if fieldFlag != "" {
+		r, err := secret.Record()
+		if err != nil {
+			exitf(err, "Secret %v has no fields", args[0])
+		}
+		value, err := r.Field(fieldFlag)
+		if err != nil {
+			exitf(err, "Secret %v has no field %v", args[0], fieldFlag)
+		}
+		fmt.Println(value)
+	} else if _, err := os.Stdout.Write(secret.Data); err != nil {
+		exitf(err, "Couldn't write data to StdOut")
 	}
 
 	if Verbose {
@@ -198,6 +168,9 @@ func showSecret(cmd *cobra.Command, args []string) {
 
 }
 
+var fieldFlag = ""
+
 func init() {
 	secretCmd.AddCommand(showSecretCmd)
+	showSecretCmd.Flags().StringVar(&fieldFlag, "field", "", "show only this field of a structured secret")
 }
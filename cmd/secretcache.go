@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+// secretCache decrypts each secret once, however many times it is used,
+// so a command that needs several secrets asks for a passphrase at most
+// once per key.
+type secretCache struct {
+	v       *vault.Vault
+	secrets map[string]*vault.Secret
+	records map[string]*vault.Record
+}
+
+func newSecretCache(v *vault.Vault) *secretCache {
+	return &secretCache{v, map[string]*vault.Secret{}, map[string]*vault.Record{}}
+}
+
+// Secret returns the named secret.
+func (c *secretCache) Secret(name string) (*vault.Secret, error) {
+	if s, ok := c.secrets[name]; ok {
+		return s, nil
+	}
+	s, err := c.v.ReadSecret(name)
+	if err != nil {
+		return nil, err
+	}
+	c.secrets[name] = s
+	return s, nil
+}
+
+// Field returns a field of the named structured secret.
+func (c *secretCache) Field(name, field string) (string, error) {
+	r, ok := c.records[name]
+	if !ok {
+		s, err := c.Secret(name)
+		if err != nil {
+			return "", err
+		}
+		r, err = s.Record()
+		if err != nil {
+			return "", err
+		}
+		c.records[name] = r
+	}
+	return r.Field(field)
+}
+
+// Lookup returns the value of a reference to a secret, either its name,
+// or its name and a field as in prod/db#username. A whole secret loses its
+// trailing newlines, as it would in $(conspire secret show ...).
+func (c *secretCache) Lookup(ref string) (string, error) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return c.Field(ref[:i], ref[i+1:])
+	}
+	s, err := c.Secret(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(s.Data), "\r\n"), nil
+}
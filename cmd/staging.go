@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zoidbergconspiracy/conspire/vault"
+)
+
+// Plaintext handed to the editor is staged outside the vault, which may be
+// a git checkout or on shared storage, in a private directory on a memory
+// backed file system where there is one.
+
+// stagingDirs lists the places plaintext may be staged, best first: a
+// conspire directory in $XDG_RUNTIME_DIR or /dev/shm, both of which are
+// held in memory, or in the system temporary directory.
+func stagingDirs() []string {
+	var dirs []string
+	if run := os.Getenv("XDG_RUNTIME_DIR"); run != "" {
+		if fi, err := os.Stat(run); err == nil && fi.IsDir() {
+			dirs = append(dirs, filepath.Join(run, "conspire"))
+		}
+	}
+	if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+		dirs = append(dirs, filepath.Join("/dev/shm", "conspire-"+strconv.Itoa(os.Getuid())))
+	}
+	return append(dirs, filepath.Join(os.TempDir(), "conspire-"+strconv.Itoa(os.Getuid())))
+}
+
+// stagingDir returns the private directory used to stage plaintext,
+// creating it if necessary. It is the first of stagingDirs; memory
+// reports false if that is not held in memory.
+func stagingDir() (dir string, memory bool, err error) {
+	dirs := stagingDirs()
+	if err := privateDir(dirs[0]); err != nil {
+		return "", false, err
+	}
+	return dirs[0], len(dirs) > 1, nil
+}
+
+// privateDir makes sure dir exists, is a real directory, belongs to us,
+// and can't be read by anybody else.
+func privateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	if !ownedByUs(fi) {
+		return fmt.Errorf("%v belongs to someone else", dir)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return os.Chmod(dir, 0700)
+	}
+	return nil
+}
+
+// stagePlaintext writes data to a new file, readable only by us, in the
+// staging directory, and returns its name. The file should be removed
+// with shred.
+func stagePlaintext(name string, data []byte) (string, error) {
+	dir, memory, err := stagingDir()
+	if err != nil {
+		return "", err
+	}
+	if !memory {
+		fmt.Fprintf(os.Stderr, "WARNING: No memory backed file system was found, so the secret will be\nstaged on disk in %v.\n", dir)
+	}
+
+	// the pid tells scrubStaging whether the file is still in use
+	f, err := ioutil.TempFile(dir, fmt.Sprintf(".tmp.%d.%s.", os.Getpid(), filepath.Base(name)))
+	if err != nil {
+		return "", err
+	}
+	tmpname := f.Name()
+
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		shred(tmpname)
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		shred(tmpname)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		shred(tmpname)
+		return "", err
+	}
+	return tmpname, nil
+}
+
+// shred overwrites the file with zeros before removing it, so the
+// plaintext doesn't linger in memory or on disk after it is unlinked.
+func shred(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+
+	if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		zeros := make([]byte, 4096)
+		for left := fi.Size(); left > 0; {
+			n := int64(len(zeros))
+			if left < n {
+				n = left
+			}
+			if _, err := f.Write(zeros[:n]); err != nil {
+				break
+			}
+			left -= n
+		}
+		f.Sync()
+		f.Close()
+	}
+
+	return os.Remove(path)
+}
+
+// staleTempAge is the age after which a temporary file in the vault is
+// taken to be left over from an earlier run.
+const staleTempAge = time.Hour
+
+// scrubStaging shreds the plaintext left in the staging directories by
+// runs of conspire that have since exited, and returns the names of the
+// files it removed.
+func scrubStaging() ([]string, error) {
+	var removed []string
+	for _, dir := range stagingDirs() {
+		fi, err := os.Lstat(dir)
+		if err != nil || !fi.IsDir() || !ownedByUs(fi) {
+			continue
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return removed, err
+		}
+		for _, fi := range files {
+			// files are named .tmp.<pid>.<secret>.<random>
+			parts := strings.SplitN(fi.Name(), ".", 4)
+			if len(parts) < 4 || parts[0] != "" || parts[1] != "tmp" || !fi.Mode().IsRegular() {
+				continue
+			}
+			pid, err := strconv.Atoi(parts[2])
+			if err != nil || pid == os.Getpid() || vault.ProcessAlive(pid) {
+				continue
+			}
+			path := filepath.Join(dir, fi.Name())
+			if err := shred(path); err != nil {
+				return removed, err
+			}
+			removed = append(removed, path)
+		}
+	}
+	return removed, nil
+}
+
+// scrubVaultDir shreds the temporary files left in the vault directory by
+// interrupted writes, and the plaintext left there by older versions of
+// conspire, which staged secrets for the editor in the vault. Only files
+// older than staleTempAge are removed, so runs still in progress are left
+// alone. It returns the names of the files it removed.
+func scrubVaultDir(vaultDir string) ([]string, error) {
+	var removed []string
+	err := filepath.Walk(vaultDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path != vaultDir && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(fi.Name(), ".tmp.") || !fi.Mode().IsRegular() || time.Since(fi.ModTime()) < staleTempAge {
+			return nil
+		}
+		if err := shred(path); err != nil {
+			return err
+		}
+		removed = append(removed, path)
+		return nil
+	})
+	return removed, err
+}
+
+// autoScrub scrubs leftover plaintext from the staging directories and
+// the vault, and says what it removed.
+func autoScrub(vaultDir string) {
+	staged, err := scrubStaging()
+	for _, path := range staged {
+		fmt.Fprintf(os.Stderr, "Removed plaintext left behind by an earlier edit: %v\n", path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't scrub leftover plaintext\n%v\n", err)
+	}
+
+	left, err := scrubVaultDir(vaultDir)
+	for _, path := range left {
+		fmt.Fprintf(os.Stderr, "Removed temporary file left in the vault: %v\n", path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't scrub leftover temporary files in %v\n%v\n", vaultDir, err)
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(vaultCmd)
+	vaultCmd.AddCommand(migrateVaultCmd)
+	vaultCmd.AddCommand(scrubVaultCmd)
+}
+
+// vaultCmd represents the vault command
+var vaultCmd = &cobra.Command{
+	Use:   "vault",
+	Short: "conspire vault operations",
+	Long:  `Operations on the vault as a whole.`,
+}
+
+// migrateVaultCmd represents the migrate command
+var migrateVaultCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "move a flat vault into the current layout",
+	Long: `Move the groups and secrets of a vault created by an older version of
+conspire, which keeps them side by side, into the groups/ and secrets/
+subdirectories of the current layout. Files that are neither groups nor
+secrets are left alone.
+
+Older vaults can still be read and written without migrating them, but a
+secret can't have the same name as a group until they are migrated.
+
+Example:
+
+$ conspire vault migrate
+Moved 2 groups and 14 secrets
+`,
+	Run: migrateVault,
+}
+
+// scrubVaultCmd represents the scrub command
+var scrubVaultCmd = &cobra.Command{
+	Use:   "scrub",
+	Short: "remove plaintext left behind by interrupted edits",
+	Long: `Find and securely remove plaintext left behind by earlier runs of
+conspire that were killed while editing a secret: staged copies whose
+process has exited, and copies that older versions of conspire left in the
+vault directory. Temporary files from interrupted writes to the vault are
+removed too. Files are overwritten before they are removed.
+
+This is also done automatically whenever the vault is opened.
+
+Example:
+
+$ conspire vault scrub
+Removed 1 files
+`,
+	Run: scrubVault,
+}
+
+func migrateVault(cmd *cobra.Command, args []string) {
+
+	groups, secrets, err := openVault().Migrate()
+	if err != nil {
+		exitf(err, "Couldn't migrate vault %v", VaultDir)
+	}
+
+	fmt.Printf("Moved %v groups and %v secrets\n", groups, secrets)
+
+}
+
+func scrubVault(cmd *cobra.Command, args []string) {
+
+	staged, err := scrubStaging()
+	if err != nil {
+		exitf(err, "Couldn't scrub leftover plaintext")
+	}
+	left, err := scrubVaultDir(VaultDir)
+	if err != nil {
+		exitf(err, "Couldn't scrub leftover temporary files in %v", VaultDir)
+	}
+
+	if Verbose {
+		for _, path := range append(staged, left...) {
+			fmt.Printf("Removed %v\n", path)
+		}
+	}
+	fmt.Printf("Removed %v files\n", len(staged)+len(left))
+
+}
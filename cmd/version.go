@@ -25,6 +25,7 @@ func showVersion(cmd *cobra.Command, args []string) {
 		fmt.Printf("\nConfiguration:\n")
 		fmt.Println("  Using secret keyring:  " + SecRingPath)
 		fmt.Println("  Using public keyring:  " + PubRingPath)
+		fmt.Println("  Using private keys:    " + PrivateKeysDir)
 		fmt.Println("  Using vault directory: " + VaultDir)
 	}
 }
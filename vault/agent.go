@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Agent performs private key operations on behalf of the vault, so that
+// private keys and their passphrases never enter this process. gpg-agent
+// is the usual one.
+type Agent interface {
+	// HaveKey reports whether the agent holds the private key with the
+	// given keygrip.
+	HaveKey(keygrip string) bool
+
+	// PKDecrypt decrypts an RSA ciphertext with the private key with the
+	// given keygrip, and returns it with the PKCS #1 v1.5 padding
+	// removed. The agent may show desc to the user if it needs to ask
+	// for a passphrase.
+	PKDecrypt(keygrip, desc string, ciphertext []byte) ([]byte, error)
+}
+
+// agentDecrypter is a crypto.Decrypter for a private key held by an
+// Agent, which openpgp uses to decrypt session keys.
+type agentDecrypter struct {
+	v       *Vault
+	pub     *rsa.PublicKey
+	keygrip string
+	desc    string
+}
+
+func (d *agentDecrypter) Public() crypto.PublicKey {
+	return d.pub
+}
+
+func (d *agentDecrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	plain, err := d.v.Agent.PKDecrypt(d.keygrip, d.desc, ciphertext)
+	if err != nil {
+		// openpgp discards this error, so keep it to report later
+		d.v.agentErr = err
+		return nil, err
+	}
+	return plain, nil
+}
+
+// attachAgentHeldKeys gives the primary key and subkeys of e private keys
+// that decrypt through v.Agent, for those the agent holds, and reports
+// whether there were any.
+func (v *Vault) attachAgentHeldKeys(e *openpgp.Entity) bool {
+	found := false
+
+	var names []string
+	for name := range e.Identities {
+		names = append(names, name)
+	}
+
+	attach := func(pub *packet.PublicKey) *packet.PrivateKey {
+		rsaPub, ok := pub.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil
+		}
+		grip, err := keygrip(pub)
+		if err != nil || !v.Agent.HaveKey(grip) {
+			return nil
+		}
+
+		found = true
+		return &packet.PrivateKey{
+			PublicKey: *pub,
+			PrivateKey: &agentDecrypter{v, rsaPub, grip,
+				fmt.Sprintf("Please enter the passphrase to unlock the secret key for\n\"%s\" (%016X)\nto decrypt a conspiracy secret.",
+					strings.Join(names, " "), pub.KeyId)},
+		}
+	}
+
+	e.PrivateKey = attach(e.PrimaryKey)
+	for i := range e.Subkeys {
+		e.Subkeys[i].PrivateKey = attach(e.Subkeys[i].PublicKey)
+	}
+	return found
+}
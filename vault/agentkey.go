@@ -0,0 +1,200 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Private keys managed by gpg-agent since GnuPG 2.1 are stored one per
+// file in private-keys-v1.d, named by keygrip. Each file holds an
+// S-expression, either on its own or as the Key: item of the extended key
+// format, and the secret parameters are usually protected with the
+// passphrase. Only RSA keys are supported.
+
+var (
+	errUnsupportedKey = errors.New("unsupported private key type")
+	errBadPassphrase  = errors.New("wrong passphrase")
+)
+
+// keygrip returns the keygrip of an OpenPGP public key, which gpg-agent
+// uses to name its key files.
+func keygrip(pub *packet.PublicKey) (string, error) {
+	rsaPub, ok := pub.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return "", errUnsupportedKey
+	}
+
+	// the modulus is hashed as an unsigned integer in two's complement
+	n := rsaPub.N.Bytes()
+	if n[0]&0x80 != 0 {
+		n = append([]byte{0}, n...)
+	}
+	return fmt.Sprintf("%X", sha1.Sum(n)), nil
+}
+
+// readAgentKey reads a private key file from private-keys-v1.d.
+func readAgentKey(path string) (*sexp, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The extended format is a list of "Name: value" items, where values
+	// are continued on lines starting with a space.
+	if len(data) > 0 && data[0] != '(' {
+		var key []byte
+		inKey := false
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			switch {
+			case len(line) > 0 && (line[0] == ' ' || line[0] == '\t'):
+				if inKey {
+					key = append(key, line...)
+				}
+			case bytes.HasPrefix(line, []byte("Key:")):
+				inKey = true
+				key = append(key, line[4:]...)
+			default:
+				inKey = false
+			}
+		}
+		data = key
+	}
+
+	key, err := parseSexp(data)
+	if err != nil {
+		return nil, err
+	}
+	switch key.name() {
+	case "private-key", "protected-private-key":
+	default:
+		return nil, errUnsupportedKey
+	}
+	if len(key.List) < 2 || key.List[1].name() != "rsa" {
+		return nil, errUnsupportedKey
+	}
+	return key, nil
+}
+
+// agentKeyProtected reports whether the key needs a passphrase.
+func agentKeyProtected(key *sexp) bool {
+	return key.name() == "protected-private-key"
+}
+
+// unprotectAgentKey returns the RSA private key held in a key file read
+// with readAgentKey, decrypting it with passphrase if it is protected.
+func unprotectAgentKey(key *sexp, passphrase []byte) (*rsa.PrivateKey, error) {
+	params := key.List[1]
+
+	if agentKeyProtected(key) {
+		prot := params.find("protected")
+		if prot == nil || len(prot.List) != 4 || len(prot.List[2].List) != 2 {
+			return nil, errSexp
+		}
+		mode := string(prot.List[1].Atom)
+		s2k := prot.List[2].List[0]
+		iv := prot.List[2].List[1].Atom
+		data := prot.List[3].Atom
+
+		if len(s2k.List) != 3 || s2k.name() != "sha1" {
+			return nil, errUnsupportedKey
+		}
+		salt := s2k.List[1].Atom
+		count, err := strconv.Atoi(string(s2k.List[2].Atom))
+		if err != nil {
+			return nil, errSexp
+		}
+
+		block, err := aes.NewCipher(s2kIterated(passphrase, salt, count, 16))
+		if err != nil {
+			return nil, err
+		}
+
+		var plain []byte
+		switch mode {
+		case "openpgp-s2k3-ocb-aes":
+			// The rest of the key, without the protected list, is
+			// authenticated along with it.
+			aad := &sexp{List: []*sexp{}}
+			for _, e := range params.List {
+				if e != prot {
+					aad.List = append(aad.List, e)
+				}
+			}
+			plain, err = ocbOpen(block, iv, data, aad.canonical())
+			if err != nil {
+				return nil, errBadPassphrase
+			}
+
+		case "openpgp-s2k3-sha1-aes-cbc":
+			if len(iv) != aes.BlockSize || len(data)%aes.BlockSize != 0 {
+				return nil, errSexp
+			}
+			plain = make([]byte, len(data))
+			cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+		default:
+			return nil, errUnsupportedKey
+		}
+
+		// The plaintext is ((secret parameters) ...), followed by
+		// padding in CBC mode.
+		p := &sexpParser{data: plain}
+		secret, err := p.parse()
+		if err != nil || len(secret.List) == 0 {
+			return nil, errBadPassphrase
+		}
+		secret = secret.List[0]
+
+		params = &sexp{List: append(params.List[:len(params.List):len(params.List)], secret.List...)}
+	}
+
+	mpi := func(name string) *big.Int {
+		return new(big.Int).SetBytes(params.value(name))
+	}
+
+	priv := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: mpi("n"), E: int(mpi("e").Int64())},
+		D:         mpi("d"),
+		Primes:    []*big.Int{mpi("p"), mpi("q")},
+	}
+	if err := priv.Validate(); err != nil {
+		return nil, errBadPassphrase
+	}
+	priv.Precompute()
+	return priv, nil
+}
+
+// s2kIterated derives a key from a passphrase with the OpenPGP iterated
+// and salted S2K function using SHA-1.
+func s2kIterated(passphrase, salt []byte, count, size int) []byte {
+	var key []byte
+	combined := append(append([]byte{}, salt...), passphrase...)
+	if count < len(combined) {
+		count = len(combined)
+	}
+
+	for i := 0; len(key) < size; i++ {
+		h := sha1.New()
+		h.Write(make([]byte, i))
+		for written := 0; written < count; {
+			n := count - written
+			if n > len(combined) {
+				n = len(combined)
+			}
+			h.Write(combined[:n])
+			written += n
+		}
+		key = h.Sum(key)
+	}
+	return key[:size]
+}
@@ -0,0 +1,70 @@
+package vault
+
+import (
+	"crypto/rsa"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeygrip(t *testing.T) {
+	el, err := readKeybox(readFixtureKeybox(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range el {
+		if grip, err := keygrip(e.PrimaryKey); err != nil || grip != fixtureKeys[i].keygrip {
+			t.Errorf("%s: keygrip %v, %v; want %v", fixtureKeys[i].uid, grip, err, fixtureKeys[i].keygrip)
+		}
+		if grip, err := keygrip(e.Subkeys[0].PublicKey); err != nil || grip != fixtureKeys[i].subgrip {
+			t.Errorf("%s: subkey keygrip %v, %v; want %v", fixtureKeys[i].uid, grip, err, fixtureKeys[i].subgrip)
+		}
+	}
+}
+
+func TestUnprotectAgentKey(t *testing.T) {
+	el, err := readKeybox(readFixtureKeybox(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key        int // index in fixtureKeys
+		protected  bool
+		passphrase string
+		wantErr    error
+	}{
+		{0, false, "", nil},
+		{1, true, fixturePassphrase, nil},
+		{1, true, "wrong passphrase", errBadPassphrase},
+		{1, true, "", errBadPassphrase},
+		{2, true, fixturePassphrase, nil},
+		{2, true, "wrong passphrase", errBadPassphrase},
+	}
+
+	for _, tt := range tests {
+		fk := fixtureKeys[tt.key]
+		key, err := readAgentKey(filepath.Join("testdata", "gnupg", "private-keys-v1.d", fk.subgrip+".key"))
+		if err != nil {
+			t.Fatalf("%s: %v", fk.uid, err)
+		}
+		if agentKeyProtected(key) != tt.protected {
+			t.Errorf("%s: protected = %v, want %v", fk.uid, !tt.protected, tt.protected)
+		}
+
+		priv, err := unprotectAgentKey(key, []byte(tt.passphrase))
+		if err != tt.wantErr {
+			t.Errorf("%s with %q: err = %v, want %v", fk.uid, tt.passphrase, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		pub := el[tt.key].Subkeys[0].PublicKey
+		if grip, _ := keygrip(pub); grip != fk.subgrip {
+			t.Fatalf("%s: fixture subkey has keygrip %v", fk.uid, grip)
+		}
+		if rsaPub := pub.PublicKey.(*rsa.PublicKey); rsaPub.N.Cmp(priv.N) != 0 || rsaPub.E != priv.E {
+			t.Errorf("%s: private key doesn't match the public key", fk.uid)
+		}
+	}
+}
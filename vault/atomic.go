@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// tempFile is the part of *os.File that writeFile uses, so that tests can
+// make it fail part way.
+type tempFile interface {
+	Name() string
+	Write(b []byte) (int, error)
+	Chmod(mode os.FileMode) error
+	Sync() error
+	Close() error
+}
+
+// createTemp creates the temporary file writeFile writes to.
+var createTemp = func(dir, pattern string) (tempFile, error) {
+	return ioutil.TempFile(dir, pattern)
+}
+
+// writeFile replaces the file at path with data so that, even after a
+// crash, the file holds either the old data or the new, never a mix. The
+// data goes to a temporary file in the same directory, which is synced
+// and renamed over the old file. An existing file keeps its permissions;
+// a new one gets perm.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := createTemp(dir, ".tmp."+base+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	// until the rename, a failure leaves the old file as it was
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(perm); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
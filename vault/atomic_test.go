@@ -0,0 +1,136 @@
+package vault
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failingFile is a temporary file that fails at one step of writeFile, as
+// if the disk filled up or the machine went away.
+type failingFile struct {
+	*os.File
+	step string
+}
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	if f.step == "write" {
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errInjected
+	}
+	return f.File.Write(b)
+}
+
+func (f *failingFile) Chmod(mode os.FileMode) error {
+	if f.step == "chmod" {
+		return errInjected
+	}
+	return f.File.Chmod(mode)
+}
+
+func (f *failingFile) Sync() error {
+	if f.step == "sync" {
+		return errInjected
+	}
+	return f.File.Sync()
+}
+
+func (f *failingFile) Close() error {
+	err := f.File.Close()
+	if f.step == "close" {
+		return errInjected
+	}
+	return err
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "conspire-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestWriteFileInterrupted(t *testing.T) {
+	defer func(f func(string, string) (tempFile, error)) { createTemp = f }(createTemp)
+
+	for _, step := range []string{"write", "chmod", "sync", "close"} {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "secret")
+		if err := ioutil.WriteFile(path, []byte("old contents"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		createTemp = func(dir, pattern string) (tempFile, error) {
+			f, err := ioutil.TempFile(dir, pattern)
+			if err != nil {
+				return nil, err
+			}
+			return &failingFile{f, step}, nil
+		}
+
+		if err := writeFile(path, []byte("new contents"), 0600); err != errInjected {
+			t.Errorf("%s: writeFile returned %v, want %v", step, err, errInjected)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || string(data) != "old contents" {
+			t.Errorf("%s: file holds %q, %v; want the old contents", step, data, err)
+		}
+		if left, _ := filepath.Glob(filepath.Join(dir, ".tmp.*")); len(left) > 0 {
+			t.Errorf("%s: left temporary files %v", step, left)
+		}
+	}
+}
+
+func TestWriteFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't kept on Windows")
+	}
+
+	tests := []struct {
+		name     string
+		existing os.FileMode // 0 if there is no file yet
+		perm     os.FileMode
+		want     os.FileMode
+	}{
+		{"new file", 0, 0660, 0660},
+		{"new private file", 0, 0600, 0600},
+		{"kept private", 0600, 0660, 0600},
+		{"kept shared", 0664, 0660, 0664},
+	}
+
+	for _, tt := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "secret")
+		if tt.existing != 0 {
+			if err := ioutil.WriteFile(path, []byte("old"), tt.existing); err != nil {
+				t.Fatal(err)
+			}
+			// regardless of the umask
+			if err := os.Chmod(path, tt.existing); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := writeFile(path, []byte("new"), tt.perm); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if fi.Mode().Perm() != tt.want {
+			t.Errorf("%s: mode %v, want %v", tt.name, fi.Mode().Perm(), tt.want)
+		}
+		if data, _ := ioutil.ReadFile(path); string(data) != "new" {
+			t.Errorf("%s: file holds %q, want %q", tt.name, data, "new")
+		}
+	}
+}
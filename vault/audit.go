@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"sort"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// Access describes who can read a secret, and how that differs from the
+// current members of the group it was encrypted for.
+type Access struct {
+	Name string
+
+	// Group is the group the secret was encrypted for, or "" if it isn't
+	// known.
+	Group string
+
+	// Readers are the primary key ids of the recipients of the secret.
+	// Recipients whose keys aren't known are listed by the key id they
+	// were encrypted to.
+	Readers []uint64
+
+	// Stale lists readers who are no longer members of the group, and
+	// Missing lists members of the group who aren't readers.
+	Stale   []uint64
+	Missing []uint64
+
+	// Err is set if the secret couldn't be audited.
+	Err error
+}
+
+// Audit is a report of who can read each secret in the vault.
+type Audit struct {
+	Secrets []*Access
+
+	// Keys holds the known keys, from the groups of the vault and the
+	// public keyring, by primary key id.
+	Keys map[uint64]*openpgp.Entity
+}
+
+// Audit reports who can read each secret in the vault. Only the encrypted
+// session keys of each secret are read, so no private key is needed.
+func (v *Vault) Audit() (*Audit, error) {
+	a := &Audit{Keys: make(map[uint64]*openpgp.Entity)}
+
+	// map the key ids secrets are encrypted to onto their primary keys
+	primary := make(map[uint64]uint64)
+	addKeys := func(el openpgp.EntityList) {
+		for _, e := range el {
+			id := e.PrimaryKey.KeyId
+			if _, ok := a.Keys[id]; !ok {
+				a.Keys[id] = e
+			}
+			primary[id] = id
+			for _, sub := range e.Subkeys {
+				primary[sub.PublicKey.KeyId] = id
+			}
+		}
+	}
+
+	names, err := v.Groups()
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]*Group)
+	for _, name := range names {
+		g, err := v.ReadGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		groups[name] = g
+		addKeys(g.Members)
+	}
+	if pubList, err := readKeyRing(v.PubRingPath); err == nil {
+		addKeys(pubList)
+	}
+
+	secrets, err := v.Secrets()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range secrets {
+		acc := &Access{Name: name}
+		a.Secrets = append(a.Secrets, acc)
+
+		keyids, err := v.Recipients(name)
+		if err != nil {
+			acc.Err = err
+			continue
+		}
+		readers := make(map[uint64]bool)
+		for _, kid := range keyids {
+			if id, ok := primary[kid]; ok {
+				kid = id
+			}
+			if !readers[kid] {
+				readers[kid] = true
+				acc.Readers = append(acc.Readers, kid)
+			}
+		}
+		sortKeyIds(acc.Readers)
+
+		acc.Group, err = v.Owner(name)
+		if err != nil {
+			acc.Err = err
+			continue
+		}
+		if acc.Group == "" {
+			continue
+		}
+		g := groups[acc.Group]
+		if g == nil {
+			acc.Err = &Error{"audit", acc.Group, ErrGroupNotFound, nil}
+			continue
+		}
+
+		members := make(map[uint64]bool)
+		for _, e := range g.Members {
+			members[e.PrimaryKey.KeyId] = true
+			if !readers[e.PrimaryKey.KeyId] {
+				acc.Missing = append(acc.Missing, e.PrimaryKey.KeyId)
+			}
+		}
+		for _, id := range acc.Readers {
+			if !members[id] {
+				acc.Stale = append(acc.Stale, id)
+			}
+		}
+		sortKeyIds(acc.Missing)
+	}
+
+	return a, nil
+}
+
+func sortKeyIds(ids []uint64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A directory of secrets may be bound to a group by a group file in it,
+// which holds the group's name, much like pass's .gpg-id. Secrets in the
+// directory and below it are encrypted for that group unless another is
+// named, or a deeper directory is bound to another group.
+const groupFile = ".group"
+
+// SecretGroup returns the group the named secret is encrypted for unless
+// told otherwise: the group recorded in its metadata, if it has been
+// written before, otherwise the group bound to its directory or the
+// nearest one above it, or DefaultGroup.
+func (v *Vault) SecretGroup(name string) string {
+	if m, err := v.ReadMetadata(name); err == nil && m.Group != "" {
+		return m.Group
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if g := v.DirGroup(dir); g != "" {
+			return g
+		}
+		if dir == "." {
+			return DefaultGroup
+		}
+	}
+}
+
+// DirGroup returns the group bound to the given directory of secrets
+// itself, or "" if it has none. The top of the vault is ".".
+func (v *Vault) DirGroup(dir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(v.secretDir(), filepath.FromSlash(dir), groupFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// BindGroup binds the given directory of secrets to a group, creating the
+// directory if necessary. The top of the vault is ".". If group is "",
+// any binding is removed.
+func (v *Vault) BindGroup(dir, group string) error {
+	if dir != "." {
+		if err := checkName(dir); err != nil {
+			return &Error{"bind group", dir, ErrBadName, nil}
+		}
+	}
+	file := filepath.Join(v.secretDir(), filepath.FromSlash(dir), groupFile)
+
+	if group == "" {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return &Error{"bind group", dir, nil, err}
+		}
+		return nil
+	}
+
+	if _, err := v.ReadGroup(group); err != nil {
+		return err
+	}
+	if err := v.prepare(file); err != nil {
+		return &Error{"bind group", dir, nil, err}
+	}
+	if err := writeFile(file, []byte(group+"\n"), 0660); err != nil {
+		return &Error{"bind group", dir, nil, err}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package vault
+
+import (
+	"errors"
+)
+
+// Kinds of failure reported by vault operations. An *Error returned by
+// the vault matches one of these with errors.Is when the cause is known.
+var (
+	ErrNotFound      = errors.New("secret not found")
+	ErrGroupNotFound = errors.New("group not found")
+	ErrBadGroup      = errors.New("group file is corrupt")
+	ErrDecrypt       = errors.New("decryption failed")
+	ErrNoKey         = errors.New("no matching private key")
+	ErrBadKeyId      = errors.New("invalid key id")
+	ErrNoAgent       = errors.New("gpg-agent unavailable")
+	ErrBadName       = errors.New("invalid secret name")
+	ErrLocked        = errors.New("locked by another process")
+	ErrConflict      = errors.New("changed since it was read")
+	ErrExists        = errors.New("secret already exists")
+	ErrBadFormat     = errors.New("not a structured secret")
+	ErrNoField       = errors.New("field not found")
+)
+
+// Error records a failed vault operation, the group, secret or file it
+// was operating on, and the kind of failure if known.
+type Error struct {
+	Op   string
+	Name string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Op + " " + e.Name + ": " + e.Kind.Error()
+	}
+	return e.Op + " " + e.Name + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of this error.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
@@ -0,0 +1,257 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// DefaultGroup is the group used when none is named.
+const DefaultGroup = "default"
+
+// Group is a named set of OpenPGP public keys to which secrets are
+// encrypted.
+type Group struct {
+	Name    string
+	Members openpgp.EntityList
+}
+
+// ParseKeyId parses a long (16 hex digit) OpenPGP key id.
+func ParseKeyId(s string) (uint64, error) {
+	kid, err := hex.DecodeString(s)
+	if err != nil || len(kid) != 8 {
+		return 0, &Error{"parse key id", s, ErrBadKeyId, nil}
+	}
+	return binary.BigEndian.Uint64(kid), nil
+}
+
+// Groups returns the names of the groups in the vault, in order. A group
+// is any file holding an armored public key block.
+func (v *Vault) Groups() ([]string, error) {
+	dir := v.groupDir()
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, &Error{"list groups", dir, nil, err}
+	}
+
+	var names []string
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		if armorType(v.groupPath(fi.Name())) == openpgp.PublicKeyType {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// KeyRevoked reports whether the key has been revoked.
+func KeyRevoked(e *openpgp.Entity) bool {
+	return len(e.Revocations) > 0
+}
+
+// KeyExpired reports whether the key has expired at time t, according to
+// the most recent self-signature on its identities.
+func KeyExpired(e *openpgp.Entity, t time.Time) bool {
+	var latest *packet.Signature
+	for _, id := range e.Identities {
+		sig := id.SelfSignature
+		if sig != nil && (latest == nil || sig.CreationTime.After(latest.CreationTime)) {
+			latest = sig
+		}
+	}
+	if latest == nil || latest.KeyLifetimeSecs == nil || *latest.KeyLifetimeSecs == 0 {
+		return false
+	}
+
+	// the lifetime counts from the creation of the key, not the signature
+	expiry := e.PrimaryKey.CreationTime.Add(time.Duration(*latest.KeyLifetimeSecs) * time.Second)
+	return t.After(expiry)
+}
+
+// ReadGroup reads the named group from the vault.
+func (v *Vault) ReadGroup(name string) (*Group, error) {
+	f, err := os.Open(v.groupPath(name))
+	if os.IsNotExist(err) {
+		return nil, &Error{"read group", name, ErrGroupNotFound, err}
+	} else if err != nil {
+		return nil, &Error{"read group", name, nil, err}
+	}
+	defer f.Close()
+
+	members, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, &Error{"read group", name, ErrBadGroup, err}
+	}
+
+	return &Group{name, members}, nil
+}
+
+// WriteGroup writes the group to the vault, replacing any existing group
+// of the same name.
+func (v *Vault) WriteGroup(g *Group) error {
+	lock, err := v.LockGroup(g.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	path := v.groupPath(g.Name)
+	if v.Layout == LayoutFlat && armorType(path) == "PGP MESSAGE" {
+		return &Error{"write group", g.Name, nil, errors.New("a secret of that name exists")}
+	}
+	if err := v.prepare(path); err != nil {
+		return &Error{"write group", g.Name, nil, err}
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return &Error{"write group", g.Name, nil, err}
+	}
+	for _, e := range g.Members {
+		if err := e.Serialize(w); err != nil {
+			return &Error{"write group", g.Name, nil, err}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return &Error{"write group", g.Name, nil, err}
+	}
+
+	if err := writeFile(path, buf.Bytes(), 0660); err != nil {
+		return &Error{"write group", g.Name, nil, err}
+	}
+	return nil
+}
+
+// AddMembers adds the keys with the given key ids, taken from the public
+// keyring, to the named group. The group is created if it doesn't exist.
+// Key ids that are invalid or already members are skipped. Secrets
+// encrypted for the group are not recrypted.
+func (v *Vault) AddMembers(name string, keyids ...string) (added, skipped int, err error) {
+	pubList, err := readKeyRing(v.PubRingPath)
+	if err != nil {
+		return 0, 0, &Error{"read keyring", v.PubRingPath, nil, err}
+	}
+
+	lock, err := v.LockGroup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lock.Unlock()
+
+	g, err := v.ReadGroup(name)
+	if err != nil {
+		if !errors.Is(err, ErrGroupNotFound) {
+			return 0, 0, err
+		}
+		v.logf("Group file %s doesn't exist. Will create it.\n", name)
+		g = &Group{Name: name}
+	}
+
+	if err := v.recordOwners(g); err != nil {
+		return 0, 0, err
+	}
+
+	v.logf("Adding users to group %s\n", name)
+
+	for _, keyid := range keyids {
+
+		ukid, err := ParseKeyId(keyid)
+		if err != nil {
+			v.logf("Key %v is not a valid KeyID. Skipping.\n", keyid)
+			skipped += 1
+			continue
+		}
+
+		// Is the key already in the group?
+		if len(g.Members.KeysById(ukid)) > 0 {
+			v.logf("Key id %X is already in the group. Skipped.\n", ukid)
+			skipped += 1
+			continue
+		}
+
+		// Get key details from the public keychain and add
+		match := pubList.KeysById(ukid)
+		if len(match) == 0 {
+			v.logf("Key id %X is not in the public keyring. Skipped.\n", ukid)
+			skipped += 1
+			continue
+		}
+		for _, m := range match {
+			for _, id := range m.Entity.Identities {
+				v.logf("Adding key id %X (%v)\n", ukid, id.Name)
+				break
+			}
+			g.Members = append(g.Members, m.Entity)
+			added += 1
+		}
+	}
+
+	return added, skipped, v.WriteGroup(g)
+}
+
+// RemoveMembers removes the keys with the given key ids from the named
+// group. Key ids that are invalid or not members are skipped. Secrets
+// encrypted for the group are not recrypted, so the removed members can
+// still read them until they are.
+func (v *Vault) RemoveMembers(name string, keyids ...string) (deleted, skipped int, err error) {
+	lock, err := v.LockGroup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lock.Unlock()
+
+	g, err := v.ReadGroup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := v.recordOwners(g); err != nil {
+		return 0, 0, err
+	}
+
+	v.logf("Deleting users from group %s\n", name)
+
+	for _, keyid := range keyids {
+
+		ukid, err := ParseKeyId(keyid)
+		if err != nil {
+			v.logf("Key %v is not a valid KeyID. Skipping.\n", keyid)
+			skipped += 1
+			continue
+		}
+
+		members := g.Members[:0]
+		for _, e := range g.Members {
+			if e.PrimaryKey.KeyId == ukid {
+				deleted += 1
+				v.logf("Key id %X deleted\n", ukid)
+				continue
+			}
+			members = append(members, e)
+		}
+
+		if len(members) == len(g.Members) {
+			v.logf("Key id %X not found. Skipped.\n", ukid)
+			skipped += 1
+		}
+		g.Members = members
+	}
+
+	return deleted, skipped, v.WriteGroup(g)
+}
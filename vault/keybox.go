@@ -0,0 +1,68 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// GnuPG 2.1 and later keep public keys in a keybox (pubring.kbx) rather
+// than a plain OpenPGP keyring. A keybox is a sequence of blobs, each
+// starting with a 4 byte length and a 1 byte type. OpenPGP blobs hold a
+// whole transferable public key, whose offset and length within the blob
+// follow the type, version and flags.
+
+const (
+	kbxBlobHeader  = 1
+	kbxBlobOpenPGP = 2
+)
+
+var errKeybox = errors.New("malformed keybox")
+
+// isKeybox reports whether data starts with a keybox header blob.
+func isKeybox(data []byte) bool {
+	return len(data) >= 12 && data[4] == kbxBlobHeader && string(data[8:12]) == "KBXf"
+}
+
+// readKeybox returns the OpenPGP keys in a keybox. Keys of unsupported
+// types are skipped, as openpgp.ReadKeyRing does.
+func readKeybox(data []byte) (openpgp.EntityList, error) {
+	var el openpgp.EntityList
+
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, errKeybox
+		}
+		size := binary.BigEndian.Uint32(data)
+		if size < 5 || uint64(size) > uint64(len(data)) {
+			return nil, errKeybox
+		}
+		blob := data[:size]
+		data = data[size:]
+
+		if blob[4] != kbxBlobOpenPGP {
+			continue
+		}
+		if len(blob) < 16 {
+			return nil, errKeybox
+		}
+		off := binary.BigEndian.Uint32(blob[8:])
+		n := binary.BigEndian.Uint32(blob[12:])
+		if uint64(off)+uint64(n) > uint64(len(blob)) {
+			return nil, errKeybox
+		}
+
+		keys, err := openpgp.ReadKeyRing(bytes.NewReader(blob[off : off+n]))
+		if _, ok := err.(pgperrors.UnsupportedError); ok {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		el = append(el, keys...)
+	}
+
+	return el, nil
+}
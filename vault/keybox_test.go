@@ -0,0 +1,62 @@
+package vault
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The keys in testdata/gnupg were made with GnuPG 2.2: three RSA keys,
+// each with an encryption subkey, whose keygrips are those listed by
+// gpg --with-keygrip. The private-keys-v1.d files are the subkeys, one
+// unprotected, one protected with OCB by gpg-agent, and one protected with
+// CBC in the canonical format of GnuPG 2.0, with the passphrase
+// "right passphrase".
+var fixtureKeys = []struct {
+	uid              string
+	keygrip, subgrip string
+}{
+	{"Plain <plain@example.com>", "47B7C953A00D51D7BEAE91AAA40251CBA2394DF5", "19DD9BDC18C174D0B403C2F61B9152F52E5DB7F5"},
+	{"OCB <ocb@example.com>", "72A78867375F5E3DC6345DB2BCD47BB716A80144", "C6F848101B55B85FD8EF3D8B6D0DF35FAC285460"},
+	{"CBC <cbc@example.com>", "54C43BAA5855235E62079FA614DE0BF8BF50663F", "95F52E57E49A4071EDB82272D411E6B07B626C51"},
+}
+
+const fixturePassphrase = "right passphrase"
+
+func readFixtureKeybox(t *testing.T) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "gnupg", "pubring.kbx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadKeybox(t *testing.T) {
+	data := readFixtureKeybox(t)
+	if !isKeybox(data) {
+		t.Fatal("pubring.kbx isn't taken for a keybox")
+	}
+
+	el, err := readKeybox(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(el) != len(fixtureKeys) {
+		t.Fatalf("read %d keys, want %d", len(el), len(fixtureKeys))
+	}
+	for i, e := range el {
+		if _, ok := e.Identities[fixtureKeys[i].uid]; !ok {
+			t.Errorf("key %d: no identity %q", i, fixtureKeys[i].uid)
+		}
+		if len(e.Subkeys) != 1 {
+			t.Errorf("key %d: %d subkeys, want 1", i, len(e.Subkeys))
+		}
+	}
+
+	// cutting a blob short must not go unnoticed
+	for _, n := range []int{len(data) - 1, 33, 3} {
+		if _, err := readKeybox(data[:n]); err != errKeybox {
+			t.Errorf("keybox cut to %d bytes: err = %v, want %v", n, err, errKeybox)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// PublicKeyring returns the public keyring in a GnuPG home directory: the
+// keybox pubring.kbx if there is one, otherwise the legacy pubring.gpg.
+func PublicKeyring(home string) string {
+	kbx := filepath.Join(home, "pubring.kbx")
+	if _, err := os.Stat(kbx); err == nil {
+		return kbx
+	}
+	return filepath.Join(home, "pubring.gpg")
+}
+
+// readKeyRing reads a keybox or a binary OpenPGP keyring from path.
+func readKeyRing(path string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isKeybox(data) {
+		return readKeybox(data)
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// privateKeys returns the keys that may decrypt secrets: those in the
+// public keyring whose private keys are held by v.Agent or are in
+// PrivateKeysDir, followed by those in the legacy secret keyring if it
+// exists. The keys are kept for
+// the life of the vault, so each only needs to be unlocked once.
+func (v *Vault) privateKeys() (openpgp.EntityList, error) {
+	if v.keys != nil {
+		return v.keys, nil
+	}
+
+	keys := openpgp.EntityList{}
+	v.agentKeys = map[*packet.PrivateKey]*sexp{}
+
+	fi, err := os.Stat(v.PrivateKeysDir)
+	haveFiles := err == nil && fi.IsDir()
+
+	if v.Agent != nil || haveFiles {
+		pubList, err := readKeyRing(v.PubRingPath)
+		if err != nil {
+			return nil, &Error{"read keyring", v.PubRingPath, nil, err}
+		}
+		for _, e := range pubList {
+			if v.Agent != nil && v.attachAgentHeldKeys(e) || haveFiles && v.attachAgentKeys(e) {
+				keys = append(keys, e)
+			}
+		}
+	}
+
+	if _, err := os.Stat(v.SecRingPath); err == nil || len(keys) == 0 {
+		secList, err := readKeyRing(v.SecRingPath)
+		if os.IsNotExist(err) {
+			return nil, &Error{"read keyring", v.SecRingPath, ErrNoKey, err}
+		} else if err != nil {
+			return nil, &Error{"read keyring", v.SecRingPath, nil, err}
+		}
+		keys = append(keys, secList...)
+	}
+
+	v.keys = keys
+	return keys, nil
+}
+
+// attachAgentKeys gives the primary key and subkeys of e the private keys
+// found for them in PrivateKeysDir, and reports whether there were any.
+func (v *Vault) attachAgentKeys(e *openpgp.Entity) bool {
+	found := false
+
+	attach := func(pub *packet.PublicKey) *packet.PrivateKey {
+		grip, err := keygrip(pub)
+		if err != nil {
+			return nil
+		}
+		key, err := readAgentKey(filepath.Join(v.PrivateKeysDir, grip+".key"))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				v.logf("Skipping private key %s: %v\n", grip, err)
+			}
+			return nil
+		}
+
+		priv := &packet.PrivateKey{PublicKey: *pub, Encrypted: true}
+		if agentKeyProtected(key) {
+			v.agentKeys[priv] = key
+		} else if rsaKey, err := unprotectAgentKey(key, nil); err == nil {
+			priv.PrivateKey = rsaKey
+			priv.Encrypted = false
+		} else {
+			v.logf("Skipping private key %s: %v\n", grip, err)
+			return nil
+		}
+		found = true
+		return priv
+	}
+
+	e.PrivateKey = attach(e.PrimaryKey)
+	for i := range e.Subkeys {
+		e.Subkeys[i].PrivateKey = attach(e.Subkeys[i].PublicKey)
+	}
+	return found
+}
+
+// Unlock decrypts a private key with its passphrase, so that it can be
+// used to decrypt secrets. It is meant to be called from v.Prompt.
+func (v *Vault) Unlock(key openpgp.Key, passphrase []byte) error {
+	if k, ok := v.agentKeys[key.PrivateKey]; ok {
+		rsaKey, err := unprotectAgentKey(k, passphrase)
+		if err != nil {
+			return err
+		}
+		key.PrivateKey.PrivateKey = rsaKey
+		key.PrivateKey.Encrypted = false
+		return nil
+	}
+	return key.PrivateKey.Decrypt(passphrase)
+}
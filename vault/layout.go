@@ -0,0 +1,237 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Vault layouts. A flat vault keeps groups and secrets side by side in the
+// vault directory, so their names clash. The split layout keeps them apart
+// in the groups and secrets subdirectories, and is marked by a format file
+// holding the layout version.
+const (
+	LayoutFlat    = 1
+	LayoutSplit   = 2
+	LayoutCurrent = LayoutSplit
+)
+
+const (
+	formatFile = ".conspiracy"
+	groupsDir  = "groups"
+	secretsDir = "secrets"
+)
+
+// readLayout returns the layout of the vault in dir. A vault without a
+// format file is flat, unless it holds nothing but the groups and secrets
+// directories, or nothing at all, in which case it is new and gets the
+// current layout.
+func readLayout(dir string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, formatFile))
+	if err == nil {
+		layout, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || layout < LayoutFlat || layout > LayoutCurrent {
+			return 0, fmt.Errorf("unsupported vault format %q", strings.TrimSpace(string(data)))
+		}
+		return layout, nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if fi.IsDir() && (name == groupsDir || name == secretsDir) {
+			continue
+		}
+		return LayoutFlat, nil
+	}
+	return LayoutCurrent, nil
+}
+
+// groupDir returns the directory holding the groups of the vault.
+func (v *Vault) groupDir() string {
+	if v.Layout == LayoutFlat {
+		return v.Dir
+	}
+	return filepath.Join(v.Dir, groupsDir)
+}
+
+// secretDir returns the directory holding the secrets of the vault.
+func (v *Vault) secretDir() string {
+	if v.Layout == LayoutFlat {
+		return v.Dir
+	}
+	return filepath.Join(v.Dir, secretsDir)
+}
+
+// groupPath returns the location of the named group in the vault.
+func (v *Vault) groupPath(name string) string {
+	return filepath.Join(v.groupDir(), name)
+}
+
+// secretPath returns the location of the named secret, which must have
+// been checked with checkName, in the vault.
+func (v *Vault) secretPath(name string) string {
+	return filepath.Join(v.secretDir(), filepath.FromSlash(name))
+}
+
+// prepare makes sure the directory that will hold path exists and, for a
+// new vault, that the format file is written.
+func (v *Vault) prepare(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		return err
+	}
+	if v.Layout == LayoutFlat {
+		return nil
+	}
+	format := filepath.Join(v.Dir, formatFile)
+	if _, err := os.Stat(format); os.IsNotExist(err) {
+		return writeFile(format, []byte(strconv.Itoa(v.Layout)+"\n"), 0660)
+	}
+	return nil
+}
+
+// countSecrets returns the number of secrets in dir and below it.
+func countSecrets(dir string) int {
+	n := 0
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() && armorType(path) == "PGP MESSAGE" {
+			n += 1
+		}
+		return nil
+	})
+	return n
+}
+
+// armorType returns the type of the armored OpenPGP block at the start of
+// the file, such as "PGP MESSAGE", or "" if there isn't one.
+func armorType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			if !bytes.HasPrefix(line, []byte("-----BEGIN ")) || !bytes.HasSuffix(line, []byte("-----")) {
+				return ""
+			}
+			return string(line[len("-----BEGIN ") : len(line)-len("-----")])
+		}
+		if err != nil {
+			return ""
+		}
+	}
+}
+
+// Migrate moves the groups and secrets of a flat vault into the current
+// layout, and reports how many of each it moved. Files that are neither
+// are left where they are.
+func (v *Vault) Migrate() (groups, secrets int, err error) {
+	if v.Layout != LayoutFlat {
+		return 0, 0, &Error{"migrate", v.Dir, nil, fmt.Errorf("vault already has format %d", v.Layout)}
+	}
+
+	files, err := ioutil.ReadDir(v.Dir)
+	if err != nil {
+		return 0, 0, &Error{"migrate", v.Dir, nil, err}
+	}
+
+	for _, dir := range []string{groupsDir, secretsDir} {
+		// a group or secret may already have the name of the directory
+		path := filepath.Join(v.Dir, dir)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			if err := os.Rename(path, filepath.Join(v.Dir, ".migrate."+dir)); err != nil {
+				return groups, secrets, &Error{"migrate", dir, nil, err}
+			}
+		}
+		if err := os.MkdirAll(path, 0770); err != nil {
+			return groups, secrets, &Error{"migrate", v.Dir, nil, err}
+		}
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		// directories of secrets move as a whole
+		if fi.IsDir() {
+			if name == groupsDir || name == secretsDir {
+				continue
+			}
+			n := countSecrets(filepath.Join(v.Dir, name))
+			if n == 0 {
+				v.logf("%s holds no secrets. Skipped.\n", name)
+				continue
+			}
+			v.logf("Moving %s to %s\n", name, filepath.Join(v.Dir, secretsDir, name))
+			if err := os.Rename(filepath.Join(v.Dir, name), filepath.Join(v.Dir, secretsDir, name)); err != nil {
+				return groups, secrets, &Error{"migrate", name, nil, err}
+			}
+			secrets += n
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		from := filepath.Join(v.Dir, name)
+		if name == groupsDir || name == secretsDir {
+			from = filepath.Join(v.Dir, ".migrate."+name)
+		}
+
+		var to string
+		switch armorType(from) {
+		case "PGP PUBLIC KEY BLOCK":
+			to = filepath.Join(v.Dir, groupsDir, name)
+			groups += 1
+		case "PGP MESSAGE":
+			to = filepath.Join(v.Dir, secretsDir, name)
+			secrets += 1
+		default:
+			v.logf("%s is neither a group nor a secret. Skipped.\n", name)
+			continue
+		}
+
+		v.logf("Moving %s to %s\n", name, to)
+		if err := os.Rename(from, to); err != nil {
+			return groups, secrets, &Error{"migrate", name, nil, err}
+		}
+		if err := moveMetadata(filepath.Join(v.Dir, name), to); err != nil {
+			return groups, secrets, &Error{"migrate", name, nil, err}
+		}
+	}
+
+	// the binding of the top of the vault goes with the secrets
+	if _, err := os.Stat(filepath.Join(v.Dir, groupFile)); err == nil {
+		v.logf("Moving %s to %s\n", groupFile, filepath.Join(v.Dir, secretsDir, groupFile))
+		if err := os.Rename(filepath.Join(v.Dir, groupFile), filepath.Join(v.Dir, secretsDir, groupFile)); err != nil {
+			return groups, secrets, &Error{"migrate", groupFile, nil, err}
+		}
+	}
+
+	v.Layout = LayoutCurrent
+	format := filepath.Join(v.Dir, formatFile)
+	if err := writeFile(format, []byte(strconv.Itoa(v.Layout)+"\n"), 0660); err != nil {
+		return groups, secrets, &Error{"migrate", v.Dir, nil, err}
+	}
+	return groups, secrets, nil
+}
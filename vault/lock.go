@@ -0,0 +1,232 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"time"
+)
+
+// Secrets and groups are locked against concurrent changes with lock
+// files, which work on shared and network file systems where flock may
+// not. The lock file sits beside the secret or group, named after it
+// with a leading dot and a .lock suffix, and says who holds the lock.
+//
+// A lock is stale, and is broken, if its holder was a process on this
+// host that has exited, or if it is older than StaleLockAge.
+
+// StaleLockAge is the age after which a lock is considered abandoned.
+var StaleLockAge = 24 * time.Hour
+
+// LockInfo says who holds a lock.
+type LockInfo struct {
+	Owner string    `json:"owner"`
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Time  time.Time `json:"time"`
+}
+
+func (li *LockInfo) String() string {
+	return fmt.Sprintf("locked by %s (pid %d on %s) since %s", li.Owner, li.PID, li.Host, li.Time.Local().Format(time.RFC1123))
+}
+
+// Lock is a lock on a secret or group, held until Unlock is called.
+type Lock struct {
+	v        *Vault
+	path     string
+	released bool
+}
+
+// LockSecret locks the named secret. Locks are held per vault, so a
+// secret may be locked again by the same Vault, and is unlocked when
+// every Lock has been unlocked. If another process holds the lock, the
+// error matches ErrLocked.
+func (v *Vault) LockSecret(name string) (*Lock, error) {
+	if err := checkName(name); err != nil {
+		return nil, &Error{"lock secret", name, ErrBadName, nil}
+	}
+	dir, base := path.Split(name)
+	return v.lock("lock secret", name, v.secretPath(dir+"."+base+".lock"))
+}
+
+// LockGroup locks the named group, like LockSecret.
+func (v *Vault) LockGroup(name string) (*Lock, error) {
+	return v.lock("lock group", name, v.groupPath("."+name+".lock"))
+}
+
+func (v *Vault) lock(op, name, path string) (*Lock, error) {
+	v.locksMu.Lock()
+	defer v.locksMu.Unlock()
+
+	if v.locks[path] > 0 {
+		v.locks[path] += 1
+		return &Lock{v: v, path: path}, nil
+	}
+
+	// the lock may be the first thing written to a new vault, so mark
+	// its layout before creating its directories
+	if err := v.prepare(path); err != nil {
+		return nil, &Error{op, name, nil, err}
+	}
+
+	info := &LockInfo{PID: os.Getpid(), Time: time.Now().UTC()}
+	info.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		info.Owner = u.Username
+	} else {
+		info.Owner = os.Getenv("USER")
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, &Error{op, name, nil, err}
+	}
+
+	for try := 0; ; try++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		if err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, &Error{op, name, nil, err}
+			}
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, &Error{op, name, nil, err}
+		}
+
+		// somebody has it; break the lock once if it's stale
+		held, err := readLockInfo(path)
+		if err != nil {
+			return nil, &Error{op, name, nil, err}
+		}
+		if try > 0 || !held.stale(info.Host) {
+			return nil, &Error{op, name, ErrLocked, errors.New(held.String())}
+		}
+		broken, err := breakLock(path, held)
+		if err != nil {
+			return nil, &Error{op, name, nil, err}
+		}
+		if !broken {
+			if cur, err := readLockInfo(path); err == nil {
+				held = cur
+			}
+			return nil, &Error{op, name, ErrLocked, errors.New(held.String())}
+		}
+		v.logf("Broke stale lock on %s, %s\n", name, held)
+	}
+
+	if v.locks == nil {
+		v.locks = make(map[string]int)
+	}
+	v.locks[path] = 1
+	return &Lock{v: v, path: path}, nil
+}
+
+// Unlock releases the lock. Unlocking it again does nothing, so an early
+// Unlock can be followed by a deferred one.
+func (l *Lock) Unlock() error {
+	if l.released {
+		return nil
+	}
+	l.released = true
+
+	l.v.locksMu.Lock()
+	defer l.v.locksMu.Unlock()
+	if l.v.locks[l.path] == 0 {
+		return nil
+	}
+	l.v.locks[l.path] -= 1
+	if l.v.locks[l.path] > 0 {
+		return nil
+	}
+	delete(l.v.locks, l.path)
+	return os.Remove(l.path)
+}
+
+// ReleaseLocks releases every lock held by the vault, such as when the
+// program is interrupted.
+func (v *Vault) ReleaseLocks() {
+	v.locksMu.Lock()
+	defer v.locksMu.Unlock()
+	for path := range v.locks {
+		os.Remove(path)
+		delete(v.locks, path)
+	}
+}
+
+// staleBreakAge is the age after which the guard of a lock being broken
+// is taken to have been left by a process that died while breaking it.
+const staleBreakAge = time.Minute
+
+// breakLock removes the stale lock at path, which held describes. Two
+// processes may both find the same stale lock, and the second must not
+// remove the lock the first has since taken, so the lock is only broken
+// under a guard file and only if it is still the one judged stale. It
+// reports whether the lock is gone.
+func breakLock(path string, held *LockInfo) (bool, error) {
+	guard := path + ".break"
+	g, err := os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if os.IsExist(err) {
+		// somebody else is breaking it, or died trying
+		if fi, err := os.Stat(guard); err == nil && time.Since(fi.ModTime()) > staleBreakAge {
+			os.Remove(guard)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	g.Close()
+	defer os.Remove(guard)
+
+	again, err := readLockInfo(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !again.same(held) {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// same reports whether two descriptions are of the same lock.
+func (li *LockInfo) same(other *LockInfo) bool {
+	return li.Owner == other.Owner && li.PID == other.PID && li.Host == other.Host && li.Time.Equal(other.Time)
+}
+
+func readLockInfo(path string) (*LockInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info := &LockInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		// an unreadable lock is held by nobody we know
+		if fi, err := os.Stat(path); err == nil {
+			info.Time = fi.ModTime()
+		}
+	}
+	return info, nil
+}
+
+// stale reports whether the lock has been abandoned.
+func (li *LockInfo) stale(host string) bool {
+	if time.Since(li.Time) > StaleLockAge {
+		return true
+	}
+	return li.Host == host && li.PID > 0 && !ProcessAlive(li.PID)
+}
@@ -0,0 +1,70 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeLockInfo(t *testing.T, path string, info *LockInfo) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0660); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreakLock(t *testing.T) {
+	stale := &LockInfo{Owner: "gone", PID: 1 << 30, Host: "here", Time: time.Now().Add(-2 * StaleLockAge).UTC()}
+	taken := &LockInfo{Owner: "new", PID: os.Getpid(), Host: "here", Time: time.Now().UTC()}
+
+	tests := []struct {
+		name       string
+		lock       *LockInfo // the lock found when breaking it, nil if gone
+		guard      time.Duration
+		wantBroken bool
+		wantLock   bool // whether the lock file is left
+	}{
+		{"stale", stale, 0, true, false},
+		{"already broken", nil, 0, true, false},
+		{"taken by another", taken, 0, false, true},
+		{"being broken", stale, time.Second, false, true},
+		{"breaker died", stale, 2 * staleBreakAge, false, true},
+	}
+
+	for _, tt := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, ".secret.lock")
+		if tt.lock != nil {
+			writeLockInfo(t, path, tt.lock)
+		}
+		if tt.guard != 0 {
+			writeLockInfo(t, path+".break", nil)
+			old := time.Now().Add(-tt.guard)
+			if err := os.Chtimes(path+".break", old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		broken, err := breakLock(path, stale)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if broken != tt.wantBroken {
+			t.Errorf("%s: broken = %v, want %v", tt.name, broken, tt.wantBroken)
+		}
+		if _, err := os.Stat(path); (err == nil) != tt.wantLock {
+			t.Errorf("%s: lock left = %v, want %v", tt.name, err == nil, tt.wantLock)
+		}
+		// only a guard of a process still breaking the lock is kept
+		if _, err := os.Stat(path + ".break"); (err == nil) != (tt.name == "being broken") {
+			t.Errorf("%s: guard left = %v", tt.name, err == nil)
+		}
+	}
+}
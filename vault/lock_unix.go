@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package vault
+
+import (
+	"syscall"
+)
+
+// ProcessAlive reports whether a process with the given pid is running.
+func ProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
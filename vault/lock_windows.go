@@ -0,0 +1,8 @@
+package vault
+
+// ProcessAlive reports whether a process with the given pid is running.
+// Windows can't easily tell, so every process is taken to be running;
+// locks there only go stale with age.
+func ProcessAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,72 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Metadata is what the vault records about a secret besides its contents.
+// It is kept in a sidecar file next to the secret, named after it with a
+// leading dot and a .meta suffix, so prod/db/password has its metadata in
+// prod/db/.password.meta.
+type Metadata struct {
+	// Group is the group the secret was last encrypted for.
+	Group string `json:"group"`
+
+	// Modified is when the secret was last written.
+	Modified time.Time `json:"modified"`
+}
+
+// metadataPath returns the location of the metadata of the named secret.
+func (v *Vault) metadataPath(name string) string {
+	dir, base := path.Split(name)
+	return v.secretPath(dir + "." + base + ".meta")
+}
+
+// ReadMetadata returns the metadata of the named secret. Secrets written
+// by older versions of conspire have none, and get an empty Metadata.
+func (v *Vault) ReadMetadata(name string) (*Metadata, error) {
+	if err := checkName(name); err != nil {
+		return nil, &Error{"read metadata", name, ErrBadName, nil}
+	}
+
+	m := &Metadata{}
+	data, err := ioutil.ReadFile(v.metadataPath(name))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, &Error{"read metadata", name, nil, err}
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, &Error{"read metadata", name, nil, err}
+	}
+	return m, nil
+}
+
+// writeMetadata records the metadata of the named secret.
+func (v *Vault) writeMetadata(name string, m *Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return &Error{"write metadata", name, nil, err}
+	}
+	if err := writeFile(v.metadataPath(name), append(data, '\n'), 0660); err != nil {
+		return &Error{"write metadata", name, nil, err}
+	}
+	return nil
+}
+
+// moveMetadata moves the metadata file of a secret along with it, when a
+// vault is migrated.
+func moveMetadata(from, to string) error {
+	dir, base := filepath.Split(from)
+	meta := filepath.Join(dir, "."+base+".meta")
+	if _, err := os.Stat(meta); err != nil {
+		return nil
+	}
+	dir, base = filepath.Split(to)
+	return os.Rename(meta, filepath.Join(dir, "."+base+".meta"))
+}
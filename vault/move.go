@@ -0,0 +1,195 @@
+package vault
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RemoveSecret removes the named secret and its metadata from the vault.
+// If overwrite is true, the encrypted secret is overwritten with random
+// data before it is removed. Directories left empty are removed too.
+func (v *Vault) RemoveSecret(name string, overwrite bool) error {
+	if !v.Exists(name) {
+		return &Error{"remove secret", name, ErrNotFound, nil}
+	}
+
+	lock, err := v.LockSecret(name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	path := v.secretPath(name)
+	if overwrite {
+		if err := overwriteFile(path); err != nil {
+			return &Error{"remove secret", name, nil, err}
+		}
+	}
+	if err := os.Remove(path); err != nil {
+		return &Error{"remove secret", name, nil, err}
+	}
+	if err := os.Remove(v.metadataPath(name)); err != nil && !os.IsNotExist(err) {
+		return &Error{"remove secret", name, nil, err}
+	}
+
+	// the lock file is in the directory, so let go of it first
+	lock.Unlock()
+	v.removeEmptyDirs(filepath.Dir(path))
+	return nil
+}
+
+// MoveSecret renames a secret, along with its metadata. The secret stays
+// encrypted for the same group, even if it moves into a directory bound
+// to another. If force is false and a secret called to already exists,
+// the error matches ErrExists.
+func (v *Vault) MoveSecret(from, to string, force bool) error {
+	if err := checkName(to); err != nil {
+		return &Error{"move secret", to, ErrBadName, nil}
+	}
+	if !v.Exists(from) {
+		return &Error{"move secret", from, ErrNotFound, nil}
+	}
+
+	fromLock, err := v.LockSecret(from)
+	if err != nil {
+		return err
+	}
+	defer fromLock.Unlock()
+	toLock, err := v.LockSecret(to)
+	if err != nil {
+		return err
+	}
+	defer toLock.Unlock()
+
+	if !force && v.Exists(to) {
+		return &Error{"move secret", to, ErrExists, nil}
+	}
+
+	toPath := v.secretPath(to)
+	if err := v.prepare(toPath); err != nil {
+		return &Error{"move secret", to, nil, err}
+	}
+	if v.Layout == LayoutFlat && armorType(toPath) == "PGP PUBLIC KEY BLOCK" {
+		return &Error{"move secret", to, nil, errGroupName}
+	}
+
+	// move the metadata first, so the secret is never without it
+	if _, err := os.Stat(v.metadataPath(from)); err == nil {
+		if err := os.Rename(v.metadataPath(from), v.metadataPath(to)); err != nil {
+			return &Error{"move secret", from, nil, err}
+		}
+	} else if err := os.Remove(v.metadataPath(to)); err != nil && !os.IsNotExist(err) {
+		return &Error{"move secret", to, nil, err}
+	}
+	if err := os.Rename(v.secretPath(from), toPath); err != nil {
+		return &Error{"move secret", from, nil, err}
+	}
+
+	// the lock file is in the directory, so let go of it first
+	fromLock.Unlock()
+	v.removeEmptyDirs(filepath.Dir(v.secretPath(from)))
+	return nil
+}
+
+// CopySecret copies a secret. If group is "" or the group the secret is
+// encrypted for, the encrypted secret is copied as it is, without being
+// decrypted. Otherwise it is decrypted, calling v.Prompt if necessary,
+// and the copy is encrypted for group. If force is false and a secret
+// called to already exists, the error matches ErrExists.
+func (v *Vault) CopySecret(from, to, group string, force bool) error {
+	if err := checkName(to); err != nil {
+		return &Error{"copy secret", to, ErrBadName, nil}
+	}
+	if !v.Exists(from) {
+		return &Error{"copy secret", from, ErrNotFound, nil}
+	}
+
+	fromLock, err := v.LockSecret(from)
+	if err != nil {
+		return err
+	}
+	defer fromLock.Unlock()
+	toLock, err := v.LockSecret(to)
+	if err != nil {
+		return err
+	}
+	defer toLock.Unlock()
+
+	current := ""
+	if v.Exists(to) {
+		if !force {
+			return &Error{"copy secret", to, ErrExists, nil}
+		}
+		data, err := ioutil.ReadFile(v.secretPath(to))
+		if err != nil {
+			return &Error{"copy secret", to, nil, err}
+		}
+		current = version(data)
+	}
+
+	owner, err := v.Owner(from)
+	if err != nil {
+		return err
+	}
+
+	if group != "" && group != owner {
+		secret, err := v.ReadSecret(from)
+		if err != nil {
+			return err
+		}
+		return v.WriteSecretIf(to, group, secret.Data, current)
+	}
+
+	data, err := ioutil.ReadFile(v.secretPath(from))
+	if err != nil {
+		return &Error{"copy secret", from, nil, err}
+	}
+	toPath := v.secretPath(to)
+	if v.Layout == LayoutFlat && armorType(toPath) == "PGP PUBLIC KEY BLOCK" {
+		return &Error{"copy secret", to, nil, errGroupName}
+	}
+	if err := v.prepare(toPath); err != nil {
+		return &Error{"copy secret", to, nil, err}
+	}
+	if err := writeFile(toPath, data, 0660); err != nil {
+		return &Error{"copy secret", to, nil, err}
+	}
+	return v.writeMetadata(to, &Metadata{Group: owner, Modified: time.Now().UTC()})
+}
+
+// removeEmptyDirs removes dir and its parents, up to the directory of
+// secrets, as long as they are empty.
+func (v *Vault) removeEmptyDirs(dir string) {
+	top := v.secretDir()
+	for dir != top && len(dir) > len(top) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// overwriteFile overwrites the contents of the file with random data.
+func overwriteFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, rand.Reader, fi.Size()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
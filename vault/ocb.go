@@ -0,0 +1,134 @@
+package vault
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"math/bits"
+)
+
+// ocbOpen decrypts and authenticates ciphertext (with its 16 byte tag
+// appended) in OCB mode, as described in RFC 7253. gpg-agent protects
+// private keys this way since GnuPG 2.1.
+func ocbOpen(b cipher.Block, nonce, ciphertext, aad []byte) ([]byte, error) {
+	const bs = 16
+	if b.BlockSize() != bs || len(nonce) == 0 || len(nonce) > 15 || len(ciphertext) < bs {
+		return nil, errors.New("ocb: invalid parameters")
+	}
+	tag := ciphertext[len(ciphertext)-bs:]
+	ciphertext = ciphertext[:len(ciphertext)-bs]
+
+	// L_*, L_$ and L_i
+	lstar := make([]byte, bs)
+	b.Encrypt(lstar, lstar)
+	ldollar := ocbDouble(lstar)
+	l := [][]byte{ocbDouble(ldollar)}
+	li := func(i int) []byte {
+		for len(l) <= i {
+			l = append(l, ocbDouble(l[len(l)-1]))
+		}
+		return l[i]
+	}
+
+	// Offset_0 from the nonce, for a 128 bit tag
+	n := make([]byte, bs)
+	copy(n[bs-len(nonce):], nonce)
+	n[bs-len(nonce)-1] |= 1
+	bottom := uint(n[bs-1] & 0x3f)
+	n[bs-1] &^= 0x3f
+	ktop := make([]byte, bs)
+	b.Encrypt(ktop, n)
+	stretch := make([]byte, bs+8)
+	copy(stretch, ktop)
+	for i := 0; i < 8; i++ {
+		stretch[bs+i] = ktop[i] ^ ktop[i+1]
+	}
+	offset := make([]byte, bs)
+	shift, byteShift := bottom%8, bottom/8
+	for i := 0; i < bs; i++ {
+		offset[i] = stretch[uint(i)+byteShift] << shift
+		if shift > 0 {
+			offset[i] |= stretch[uint(i)+byteShift+1] >> (8 - shift)
+		}
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	checksum := make([]byte, bs)
+	tmp := make([]byte, bs)
+	i := 1
+	for ; len(ciphertext)-(i-1)*bs >= bs; i++ {
+		c := ciphertext[(i-1)*bs : i*bs]
+		p := plaintext[(i-1)*bs : i*bs]
+		ocbXor(offset, offset, li(bits.TrailingZeros(uint(i))))
+		ocbXor(tmp, c, offset)
+		b.Decrypt(tmp, tmp)
+		ocbXor(p, tmp, offset)
+		ocbXor(checksum, checksum, p)
+	}
+	if rest := ciphertext[(i-1)*bs:]; len(rest) > 0 {
+		p := plaintext[(i-1)*bs:]
+		ocbXor(offset, offset, lstar)
+		b.Encrypt(tmp, offset)
+		for j := range rest {
+			p[j] = rest[j] ^ tmp[j]
+			checksum[j] ^= p[j]
+		}
+		checksum[len(rest)] ^= 0x80
+	}
+
+	ocbXor(tmp, checksum, offset)
+	ocbXor(tmp, tmp, ldollar)
+	b.Encrypt(tmp, tmp)
+	ocbXor(tmp, tmp, ocbHash(b, aad, lstar, li))
+
+	if subtle.ConstantTimeCompare(tmp, tag) != 1 {
+		return nil, errors.New("ocb: message authentication failed")
+	}
+	return plaintext, nil
+}
+
+// ocbHash computes HASH(K, A) of RFC 7253.
+func ocbHash(b cipher.Block, aad, lstar []byte, li func(int) []byte) []byte {
+	const bs = 16
+	sum := make([]byte, bs)
+	offset := make([]byte, bs)
+	tmp := make([]byte, bs)
+	i := 1
+	for ; len(aad)-(i-1)*bs >= bs; i++ {
+		ocbXor(offset, offset, li(bits.TrailingZeros(uint(i))))
+		ocbXor(tmp, aad[(i-1)*bs:i*bs], offset)
+		b.Encrypt(tmp, tmp)
+		ocbXor(sum, sum, tmp)
+	}
+	if rest := aad[(i-1)*bs:]; len(rest) > 0 {
+		ocbXor(offset, offset, lstar)
+		for j := range tmp {
+			tmp[j] = 0
+		}
+		copy(tmp, rest)
+		tmp[len(rest)] = 0x80
+		ocbXor(tmp, tmp, offset)
+		b.Encrypt(tmp, tmp)
+		ocbXor(sum, sum, tmp)
+	}
+	return sum
+}
+
+// ocbDouble returns s doubled in GF(2^128).
+func ocbDouble(s []byte) []byte {
+	d := make([]byte, len(s))
+	for i := 0; i < len(s)-1; i++ {
+		d[i] = s[i]<<1 | s[i+1]>>7
+	}
+	d[len(s)-1] = s[len(s)-1] << 1
+	if s[0]&0x80 != 0 {
+		d[len(s)-1] ^= 0x87
+	}
+	return d
+}
+
+func ocbXor(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,201 @@
+package vault
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Recipients returns the key ids the named secret is encrypted to. They
+// are read from the start of the message, so no private key is needed.
+func (v *Vault) Recipients(name string) ([]uint64, error) {
+	if err := checkName(name); err != nil {
+		return nil, &Error{"read recipients", name, ErrBadName, nil}
+	}
+
+	file, err := os.Open(v.secretPath(name))
+	if os.IsNotExist(err) {
+		return nil, &Error{"read recipients", name, ErrNotFound, err}
+	} else if err != nil {
+		return nil, &Error{"read recipients", name, nil, err}
+	}
+	defer file.Close()
+
+	block, err := armor.Decode(file)
+	if err != nil {
+		return nil, &Error{"decode secret", name, ErrDecrypt, err}
+	}
+
+	// the encrypted session keys come first, one for each recipient
+	var keyids []uint64
+	r := packet.NewReader(block.Body)
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, &Error{"read recipients", name, ErrDecrypt, err}
+		}
+		ek, ok := p.(*packet.EncryptedKey)
+		if !ok {
+			break
+		}
+		keyids = append(keyids, ek.KeyId)
+	}
+	return keyids, nil
+}
+
+// Owner returns the group the named secret was encrypted for, without
+// decrypting it: the group recorded in its metadata or, for secrets
+// written before metadata was kept, the group whose members are exactly
+// its recipients. It returns "" if neither is known.
+func (v *Vault) Owner(name string) (string, error) {
+	m, err := v.ReadMetadata(name)
+	if err != nil {
+		return "", err
+	}
+	if m.Group != "" {
+		return m.Group, nil
+	}
+
+	keyids, err := v.Recipients(name)
+	if err != nil {
+		return "", err
+	}
+	return v.recipientGroup(keyids), nil
+}
+
+// SecretInfo describes a secret, as far as can be told without decrypting
+// it.
+type SecretInfo struct {
+	Name string
+
+	// Size is the size of the encrypted secret.
+	Size int64
+
+	// Modified is when the secret was last written.
+	Modified time.Time
+
+	// Group is the group the secret was encrypted for, as returned by
+	// Owner, or "" if it isn't known.
+	Group string
+
+	// Recipients is the number of keys the secret is encrypted to.
+	Recipients int
+}
+
+// StatSecret describes the named secret without decrypting it.
+func (v *Vault) StatSecret(name string) (*SecretInfo, error) {
+	keyids, err := v.Recipients(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(v.secretPath(name))
+	if err != nil {
+		return nil, &Error{"stat secret", name, nil, err}
+	}
+	m, err := v.ReadMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SecretInfo{name, fi.Size(), m.Modified, m.Group, len(keyids)}
+	if info.Modified.IsZero() {
+		info.Modified = fi.ModTime()
+	}
+	if info.Group == "" {
+		info.Group = v.recipientGroup(keyids)
+	}
+	return info, nil
+}
+
+// recipientGroup works out which group a secret with no metadata was
+// encrypted for, from the key ids it was encrypted to. It returns the
+// group whose members are exactly the recipients, or "" if there is no
+// such group.
+func (v *Vault) recipientGroup(keyids []uint64) string {
+	names, err := v.Groups()
+	if err != nil {
+		return ""
+	}
+
+	for _, name := range names {
+		g, err := v.ReadGroup(name)
+		if err == nil && encryptedFor(g, keyids) {
+			return name
+		}
+	}
+	return ""
+}
+
+// encryptedFor reports whether the members of g are exactly the owners of
+// the given recipient key ids.
+func encryptedFor(g *Group, keyids []uint64) bool {
+	if len(g.Members) == 0 {
+		return false
+	}
+
+	// each recipient must be a member, and each member a recipient
+	matched := make(map[int]bool)
+	for _, kid := range keyids {
+		found := false
+		for i, e := range g.Members {
+			if entityHasKey(e, kid) {
+				matched[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(matched) == len(g.Members)
+}
+
+// recordOwners writes metadata for the secrets without it that were
+// encrypted for the group, before its members change and that can no
+// longer be worked out from their recipients.
+func (v *Vault) recordOwners(g *Group) error {
+	names, err := v.Secrets()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		m, err := v.ReadMetadata(name)
+		if err != nil || m.Group != "" {
+			continue
+		}
+		keyids, err := v.Recipients(name)
+		if err != nil || !encryptedFor(g, keyids) {
+			continue
+		}
+
+		m.Group = g.Name
+		if fi, err := os.Stat(v.secretPath(name)); err == nil {
+			m.Modified = fi.ModTime().UTC()
+		}
+		if err := v.writeMetadata(name, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entityHasKey reports whether kid is the key id of e's primary key or one
+// of its subkeys.
+func entityHasKey(e *openpgp.Entity, kid uint64) bool {
+	if e.PrimaryKey.KeyId == kid {
+		return true
+	}
+	for _, sub := range e.Subkeys {
+		if sub.PublicKey.KeyId == kid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the way the fields of a structured secret are written.
+type Format int
+
+const (
+	// FormatLines is a password on the first line, followed by
+	// "key: value" lines, as used by pass.
+	FormatLines Format = iota + 1
+
+	// FormatYAML is a YAML mapping, starting with a "---" line.
+	FormatYAML
+
+	// FormatJSON is a JSON object.
+	FormatJSON
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatLines:
+		return "key: value"
+	case FormatYAML:
+		return "YAML"
+	case FormatJSON:
+		return "JSON"
+	}
+	return "unknown"
+}
+
+// PasswordField is the name of the password field. In FormatLines it is
+// the first line of the secret.
+const PasswordField = "password"
+
+// Field is a named value in a structured secret.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Record is a secret seen as a set of named fields, such as a username,
+// password and URL. Fields keep their order, and the rest of the secret,
+// such as YAML comments, is kept as far as possible when they change.
+// Field names are matched without regard to case.
+type Record struct {
+	Format Format
+
+	name string
+
+	// FormatLines
+	lines   []string
+	newline bool
+
+	// FormatYAML
+	doc *yaml.Node
+
+	// FormatJSON
+	keys   []string
+	values []json.RawMessage
+}
+
+// NewRecord returns an empty record for the named secret, in FormatLines.
+func NewRecord(name string) *Record {
+	return &Record{Format: FormatLines, name: name, lines: []string{""}, newline: true}
+}
+
+// NewRecordFormat returns an empty record for the named secret, in the
+// given format.
+func NewRecordFormat(name string, format Format) *Record {
+	switch format {
+	case FormatYAML:
+		doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+		return &Record{Format: FormatYAML, name: name, doc: doc}
+	case FormatJSON:
+		return &Record{Format: FormatJSON, name: name}
+	}
+	return NewRecord(name)
+}
+
+// Record parses the secret as a structured secret: a JSON object, a YAML
+// mapping after a "---" line, or a password line followed by "key: value"
+// lines. A secret starting with "{" that isn't valid JSON is taken to be
+// a password. If the secret isn't structured, the error matches
+// ErrBadFormat.
+func (s *Secret) Record() (*Record, error) {
+	r := &Record{name: s.Name}
+	var err error
+
+	text := string(s.Data)
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(text), "{") && json.Valid(s.Data):
+		r.Format = FormatJSON
+		err = r.parseJSON(s.Data)
+	case strings.TrimRight(strings.SplitN(text, "\n", 2)[0], " \t\r") == "---":
+		r.Format = FormatYAML
+		err = r.parseYAML(s.Data)
+	default:
+		r.Format = FormatLines
+		err = r.parseLines(text)
+	}
+	if err != nil {
+		return nil, &Error{"parse secret", s.Name, ErrBadFormat, err}
+	}
+	return r, nil
+}
+
+// Fields returns the fields of the record, in order. In FormatLines, the
+// password comes first.
+func (r *Record) Fields() []Field {
+	var fields []Field
+	switch r.Format {
+	case FormatLines:
+		fields = append(fields, Field{PasswordField, r.lines[0]})
+		for _, l := range r.lines[1:] {
+			if key, value, ok := splitLine(l); ok {
+				fields = append(fields, Field{key, value})
+			}
+		}
+	case FormatYAML:
+		m := r.doc.Content[0]
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			fields = append(fields, Field{m.Content[i].Value, yamlValue(m.Content[i+1])})
+		}
+	case FormatJSON:
+		for i, key := range r.keys {
+			fields = append(fields, Field{key, jsonValue(r.values[i])})
+		}
+	}
+	return fields
+}
+
+// Field returns the value of the named field. If there is no such field,
+// the error matches ErrNoField.
+func (r *Record) Field(key string) (string, error) {
+	for _, f := range r.Fields() {
+		if strings.EqualFold(f.Key, key) {
+			return f.Value, nil
+		}
+	}
+	return "", &Error{"read field", r.name + ":" + key, ErrNoField, nil}
+}
+
+// Set sets the named field, adding it to the end if there isn't one. If
+// the value can't be written in the record's format, such as a value of
+// more than one line in FormatLines, the error matches ErrBadFormat.
+func (r *Record) Set(key, value string) error {
+	if strings.TrimSpace(key) == "" || strings.ContainsAny(key, ":\n") {
+		return &Error{"set field", r.name + ":" + key, ErrBadFormat, fmt.Errorf("invalid field name %q", key)}
+	}
+
+	switch r.Format {
+	case FormatLines:
+		if strings.ContainsAny(value, "\r\n") {
+			return &Error{"set field", r.name + ":" + key, ErrBadFormat, fmt.Errorf("values must fit on one line in this secret")}
+		}
+		if strings.EqualFold(key, PasswordField) {
+			r.lines[0] = value
+			return nil
+		}
+		for i, l := range r.lines[1:] {
+			if k, _, ok := splitLine(l); ok && strings.EqualFold(k, key) {
+				r.lines[i+1] = k + ": " + value
+				return nil
+			}
+		}
+		r.lines = append(r.lines, key+": "+value)
+
+	case FormatYAML:
+		m := r.doc.Content[0]
+		// tagged so that values such as 6543 or null are quoted and
+		// read back as the strings they were set as
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			if strings.EqualFold(m.Content[i].Value, key) {
+				m.Content[i+1] = node
+				return nil
+			}
+		}
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, node)
+
+	case FormatJSON:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return &Error{"set field", r.name + ":" + key, nil, err}
+		}
+		for i, k := range r.keys {
+			if strings.EqualFold(k, key) {
+				r.values[i] = raw
+				return nil
+			}
+		}
+		r.keys = append(r.keys, key)
+		r.values = append(r.values, raw)
+	}
+	return nil
+}
+
+// Bytes returns the record written out in its format.
+func (r *Record) Bytes() ([]byte, error) {
+	switch r.Format {
+	case FormatYAML:
+		buf := bytes.NewBufferString("---\n")
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(r.doc); err != nil {
+			return nil, &Error{"write secret", r.name, nil, err}
+		}
+		if err := enc.Close(); err != nil {
+			return nil, &Error{"write secret", r.name, nil, err}
+		}
+		return buf.Bytes(), nil
+
+	case FormatJSON:
+		compact := new(bytes.Buffer)
+		compact.WriteString("{")
+		for i, key := range r.keys {
+			if i > 0 {
+				compact.WriteString(",")
+			}
+			k, _ := json.Marshal(key)
+			compact.Write(k)
+			compact.WriteString(":")
+			compact.Write(r.values[i])
+		}
+		compact.WriteString("}")
+		out := new(bytes.Buffer)
+		if err := json.Indent(out, compact.Bytes(), "", "  "); err != nil {
+			return nil, &Error{"write secret", r.name, nil, err}
+		}
+		out.WriteString("\n")
+		return out.Bytes(), nil
+	}
+
+	text := strings.Join(r.lines, "\n")
+	if r.newline {
+		text += "\n"
+	}
+	return []byte(text), nil
+}
+
+// Structured reports whether the record holds anything besides a
+// password, so that its format is worth keeping.
+func (r *Record) Structured() bool {
+	return r.Format != FormatLines || len(r.lines) > 1
+}
+
+func (r *Record) parseLines(text string) error {
+	r.newline = strings.HasSuffix(text, "\n")
+	r.lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, l := range r.lines[1:] {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		if _, _, ok := splitLine(l); !ok {
+			return fmt.Errorf("line %v is not \"key: value\"", i+2)
+		}
+	}
+	return nil
+}
+
+func (r *Record) parseYAML(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		// nothing but the "---"
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("YAML secrets must be a mapping of fields")
+	}
+	r.doc = &doc
+	return nil
+}
+
+func (r *Record) parseJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		r.keys = append(r.keys, tok.(string))
+		r.values = append(r.values, value)
+	}
+	return nil
+}
+
+// splitLine splits a "key: value" line.
+func splitLine(l string) (key, value string, ok bool) {
+	i := strings.Index(l, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	key, rest := strings.TrimSpace(l[:i]), l[i+1:]
+	if key == "" || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+// yamlValue returns a scalar as it is, and anything else as YAML.
+func yamlValue(n *yaml.Node) string {
+	if n.Kind == yaml.ScalarNode {
+		return n.Value
+	}
+	out, err := yaml.Marshal(n)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// jsonValue returns a string as it is, and anything else as JSON.
+func jsonValue(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	out := new(bytes.Buffer)
+	if json.Compact(out, raw) != nil {
+		return string(raw)
+	}
+	return out.String()
+}
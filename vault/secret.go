@@ -0,0 +1,276 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// Secret is the decrypted contents of a secret in the vault.
+type Secret struct {
+	Name string
+	Data []byte
+
+	// EncryptedTo lists the key ids the secret was encrypted for.
+	EncryptedTo []uint64
+
+	// Group is the group the secret was encrypted for, as recorded in
+	// its metadata or, for secrets written before metadata was kept,
+	// worked out from EncryptedTo. It is "" if it isn't known.
+	Group string
+
+	// Version identifies the ciphertext the secret was read from, so
+	// WriteSecretIf can tell if it has changed since.
+	Version string
+}
+
+// errGroupName reports a secret name that belongs to a group in a flat
+// vault.
+var errGroupName = errors.New("a group of that name exists")
+
+// Secret names are slash separated paths within the vault, such as
+// prod/db/password. Directories are created as needed.
+
+// checkName checks that name is a usable secret name: relative, with no
+// empty, "." or ".." elements, and no element starting with a dot, which
+// are kept for the vault's own files.
+func checkName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, '\\') {
+		return ErrBadName
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || strings.HasPrefix(elem, ".") {
+			return ErrBadName
+		}
+	}
+	return nil
+}
+
+// Exists reports whether the named secret exists in the vault. A
+// directory of secrets isn't a secret.
+func (v *Vault) Exists(name string) bool {
+	if checkName(name) != nil {
+		return false
+	}
+	path := v.secretPath(name)
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	// in a flat vault, the name may belong to a group instead
+	if v.Layout == LayoutFlat {
+		return armorType(path) != openpgp.PublicKeyType
+	}
+	return armorType(path) == "PGP MESSAGE"
+}
+
+// Secrets returns the names of all the secrets in the vault, in order.
+func (v *Vault) Secrets() ([]string, error) {
+	root := v.secretDir()
+
+	var names []string
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), ".") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() && armorType(path) == "PGP MESSAGE" {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &Error{"list secrets", root, nil, err}
+	}
+	return names, nil
+}
+
+// ReadSecret reads and decrypts the named secret, calling v.Prompt to
+// unlock the private key if necessary.
+func (v *Vault) ReadSecret(name string) (*Secret, error) {
+	if err := checkName(name); err != nil {
+		return nil, &Error{"read secret", name, ErrBadName, nil}
+	}
+
+	path := v.secretPath(name)
+	if v.Layout == LayoutFlat && armorType(path) == openpgp.PublicKeyType {
+		return nil, &Error{"read secret", name, ErrNotFound, errors.New("that is a group, not a secret")}
+	}
+
+	ciphertext, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, &Error{"read secret", name, ErrNotFound, err}
+	} else if err != nil {
+		return nil, &Error{"read secret", name, nil, err}
+	}
+
+	entityList, err := v.privateKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := armor.Decode(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, &Error{"decode secret", name, ErrDecrypt, err}
+	}
+
+	v.agentErr = nil
+	md, err := openpgp.ReadMessage(block.Body, entityList, v.Prompt, nil)
+	if err == pgperrors.ErrKeyIncorrect && v.agentErr != nil {
+		// the agent had the key but couldn't use it
+		err = v.agentErr
+	}
+	if err != nil {
+		return nil, &Error{"decrypt secret", name, decryptKind(err), err}
+	}
+
+	data, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, &Error{"decrypt secret", name, ErrDecrypt, err}
+	}
+
+	meta, err := v.ReadMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Group == "" {
+		meta.Group = v.recipientGroup(md.EncryptedToKeyIds)
+	}
+
+	return &Secret{name, data, md.EncryptedToKeyIds, meta.Group, version(ciphertext)}, nil
+}
+
+// decryptKind classifies an error from openpgp.ReadMessage, which may
+// have come from the prompt function.
+func decryptKind(err error) error {
+	switch {
+	case err == pgperrors.ErrKeyIncorrect, errors.Is(err, ErrNoKey):
+		return ErrNoKey
+	case errors.Is(err, ErrNoAgent):
+		return ErrNoAgent
+	}
+	return ErrDecrypt
+}
+
+// version returns the Version of a secret with the given ciphertext.
+func version(ciphertext []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(ciphertext))
+}
+
+// WriteSecret encrypts data for the members of group and stores it in the
+// vault as the named secret, replacing any existing secret, and records
+// the group in the secret's metadata.
+func (v *Vault) WriteSecret(name, group string, data []byte) error {
+	return v.writeSecret(name, group, data, nil)
+}
+
+// WriteSecretIf is like WriteSecret, but only replaces the secret if it
+// hasn't changed since it was read with the given Version, or creates it
+// if version is "" and it doesn't exist. Otherwise the error matches
+// ErrConflict.
+func (v *Vault) WriteSecretIf(name, group string, data []byte, version string) error {
+	return v.writeSecret(name, group, data, &version)
+}
+
+func (v *Vault) writeSecret(name, group string, data []byte, ifVersion *string) error {
+	if err := checkName(name); err != nil {
+		return &Error{"write secret", name, ErrBadName, nil}
+	}
+
+	encrypted, err := v.Encrypt(group, data)
+	if err != nil {
+		return err
+	}
+
+	lock, err := v.LockSecret(name)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	path := v.secretPath(name)
+	if v.Layout == LayoutFlat && armorType(path) == openpgp.PublicKeyType {
+		return &Error{"write secret", name, nil, errGroupName}
+	}
+
+	if ifVersion != nil {
+		current := ""
+		if old, err := ioutil.ReadFile(path); err == nil {
+			current = version(old)
+		} else if !os.IsNotExist(err) {
+			return &Error{"write secret", name, nil, err}
+		}
+		if current != *ifVersion {
+			return &Error{"write secret", name, ErrConflict, nil}
+		}
+	}
+
+	if err := v.prepare(path); err != nil {
+		return &Error{"write secret", name, nil, err}
+	}
+
+	if err := writeFile(path, encrypted, 0660); err != nil {
+		return &Error{"write secret", name, nil, err}
+	}
+	return v.writeMetadata(name, &Metadata{Group: group, Modified: time.Now().UTC()})
+}
+
+// Encrypt returns data encrypted for the members of group as an armored
+// OpenPGP message.
+func (v *Vault) Encrypt(group string, data []byte) ([]byte, error) {
+	g, err := v.ReadGroup(group)
+	if err != nil {
+		return nil, err
+	}
+
+	// Print out the list of recipients
+	for _, e := range g.Members {
+		v.logf("Encrypting for %016X\n", e.PrimaryKey.KeyId)
+	}
+
+	out := new(bytes.Buffer)
+	armored, err := armor.Encode(out, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, &Error{"encrypt", group, nil, err}
+	}
+
+	w, err := openpgp.Encrypt(armored, g.Members, nil, nil, nil)
+	if err != nil {
+		return nil, &Error{"encrypt", group, nil, err}
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, &Error{"encrypt", group, nil, err}
+	}
+	if err := w.Close(); err != nil {
+		return nil, &Error{"encrypt", group, nil, err}
+	}
+	if err := armored.Close(); err != nil {
+		return nil, &Error{"encrypt", group, nil, err}
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,259 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+// sexp is a parsed S-expression, as used by gpg-agent for private keys and
+// Assuan data. An atom has Atom set and no List.
+type sexp struct {
+	Atom []byte
+	List []*sexp
+}
+
+var errSexp = errors.New("malformed S-expression")
+
+// parseSexp parses a single S-expression in canonical or advanced
+// (human readable) form.
+func parseSexp(data []byte) (*sexp, error) {
+	p := &sexpParser{data: data}
+	s, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type sexpParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *sexpParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r', '\n', '\f':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *sexpParser) parse() (*sexp, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, errSexp
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '(':
+		p.pos++
+		s := &sexp{List: []*sexp{}}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, errSexp
+			}
+			if p.data[p.pos] == ')' {
+				p.pos++
+				return s, nil
+			}
+			e, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			s.List = append(s.List, e)
+		}
+
+	case c >= '0' && c <= '9':
+		// canonical length prefixed string
+		start := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+			// a plain token that happens to start with a digit
+			p.pos = start
+			return p.token()
+		}
+		n, err := strconv.Atoi(string(p.data[start:p.pos]))
+		if err != nil || p.pos+1+n > len(p.data) {
+			return nil, errSexp
+		}
+		p.pos++
+		s := &sexp{Atom: p.data[p.pos : p.pos+n]}
+		p.pos += n
+		return s, nil
+
+	case c == '#':
+		end := bytes.IndexByte(p.data[p.pos+1:], '#')
+		if end < 0 {
+			return nil, errSexp
+		}
+		hexdata := bytes.Map(dropSpace, p.data[p.pos+1:p.pos+1+end])
+		p.pos += end + 2
+		atom := make([]byte, hex.DecodedLen(len(hexdata)))
+		if _, err := hex.Decode(atom, hexdata); err != nil {
+			return nil, errSexp
+		}
+		return &sexp{Atom: atom}, nil
+
+	case c == '|':
+		end := bytes.IndexByte(p.data[p.pos+1:], '|')
+		if end < 0 {
+			return nil, errSexp
+		}
+		b64 := bytes.Map(dropSpace, p.data[p.pos+1:p.pos+1+end])
+		p.pos += end + 2
+		atom, err := base64.StdEncoding.DecodeString(string(b64))
+		if err != nil {
+			return nil, errSexp
+		}
+		return &sexp{Atom: atom}, nil
+
+	case c == '"':
+		return p.quoted()
+	}
+
+	return p.token()
+}
+
+// token parses an unquoted token such as rsa or protected-at.
+func (p *sexpParser) token() (*sexp, error) {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '(' || c == ')' || c == '"' || c == '#' || c == '|' || dropSpace(rune(c)) < 0 {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, errSexp
+	}
+	return &sexp{Atom: p.data[start:p.pos]}, nil
+}
+
+// quoted parses a double quoted string with C style escapes.
+func (p *sexpParser) quoted() (*sexp, error) {
+	p.pos++
+	var atom []byte
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return &sexp{Atom: atom}, nil
+		case '\\':
+			if p.pos >= len(p.data) {
+				return nil, errSexp
+			}
+			c = p.data[p.pos]
+			p.pos++
+			switch c {
+			case 'b':
+				c = '\b'
+			case 't':
+				c = '\t'
+			case 'v':
+				c = '\v'
+			case 'n':
+				c = '\n'
+			case 'f':
+				c = '\f'
+			case 'r':
+				c = '\r'
+			case 'x':
+				if p.pos+2 > len(p.data) {
+					return nil, errSexp
+				}
+				n, err := strconv.ParseUint(string(p.data[p.pos:p.pos+2]), 16, 8)
+				if err != nil {
+					return nil, errSexp
+				}
+				c = byte(n)
+				p.pos += 2
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				if p.pos+2 > len(p.data) {
+					return nil, errSexp
+				}
+				n, err := strconv.ParseUint(string(p.data[p.pos-1:p.pos+2]), 8, 8)
+				if err != nil {
+					return nil, errSexp
+				}
+				c = byte(n)
+				p.pos += 2
+			case '\r', '\n':
+				// line continuation
+				if p.pos < len(p.data) && (p.data[p.pos] == '\n' || p.data[p.pos] == '\r') && p.data[p.pos] != c {
+					p.pos++
+				}
+				continue
+			}
+		}
+		atom = append(atom, c)
+	}
+	return nil, errSexp
+}
+
+func dropSpace(r rune) rune {
+	switch r {
+	case ' ', '\t', '\r', '\n', '\f':
+		return -1
+	}
+	return r
+}
+
+// canonical returns the canonical encoding of s.
+func (s *sexp) canonical() []byte {
+	var buf bytes.Buffer
+	s.writeCanonical(&buf)
+	return buf.Bytes()
+}
+
+func (s *sexp) writeCanonical(buf *bytes.Buffer) {
+	if s.List == nil {
+		buf.WriteString(strconv.Itoa(len(s.Atom)))
+		buf.WriteByte(':')
+		buf.Write(s.Atom)
+		return
+	}
+	buf.WriteByte('(')
+	for _, e := range s.List {
+		e.writeCanonical(buf)
+	}
+	buf.WriteByte(')')
+}
+
+// name returns the first atom of a list, which names it, or "".
+func (s *sexp) name() string {
+	if len(s.List) == 0 || s.List[0].List != nil {
+		return ""
+	}
+	return string(s.List[0].Atom)
+}
+
+// find returns the first element of the list with the given name.
+func (s *sexp) find(name string) *sexp {
+	for _, e := range s.List {
+		if e.name() == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// value returns the atom following the name in the named element of the
+// list, as in (n #00BF73...#), or nil if there is none.
+func (s *sexp) value(name string) []byte {
+	e := s.find(name)
+	if e == nil || len(e.List) < 2 || e.List[1].List != nil {
+		return nil
+	}
+	return e.List[1].Atom
+}
@@ -0,0 +1,97 @@
+// Package vault manages a conspiracy vault: a directory of secrets, each
+// encrypted with OpenPGP to the members of a group, where every group is
+// an armored public keyring stored in the vault alongside them.
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Vault is a directory of groups and encrypted secrets, together with the
+// local GnuPG keyrings used to read and write them.
+type Vault struct {
+	// Dir is the vault directory.
+	Dir string
+
+	// Layout is the way groups and secrets are arranged in Dir; see
+	// LayoutFlat and LayoutSplit.
+	Layout int
+
+	// SecRingPath is the keyring holding the private keys used to
+	// decrypt secrets.
+	SecRingPath string
+
+	// PubRingPath is the keyring from which new group members are
+	// taken. It may be a keybox or a plain OpenPGP keyring.
+	PubRingPath string
+
+	// PrivateKeysDir is where gpg-agent keeps private keys, one file
+	// per key, since GnuPG 2.1.
+	PrivateKeysDir string
+
+	// Prompt is called to unlock private keys when decrypting a secret.
+	Prompt openpgp.PromptFunction
+
+	// Agent, if not nil, decrypts with the private keys it holds, in
+	// preference to reading them from PrivateKeysDir or SecRingPath.
+	Agent Agent
+
+	// Logf, if not nil, is called with progress messages.
+	Logf func(format string, args ...interface{})
+
+	keys      openpgp.EntityList
+	agentKeys map[*packet.PrivateKey]*sexp
+	agentErr  error
+
+	// locksMu guards locks, which ReleaseLocks may be called on from a
+	// signal handler.
+	locksMu sync.Mutex
+	locks   map[string]int
+}
+
+// GnuPGHome returns the GnuPG home directory, which is $GNUPGHOME or
+// ~/.gnupg if that is not set.
+func GnuPGHome() string {
+	home := os.Getenv("GNUPGHOME")
+	if home == "" {
+		home = filepath.Join(os.Getenv("HOME"), ".gnupg")
+	}
+	return home
+}
+
+// Open returns the vault in dir, using the keyrings from GnuPGHome.
+func Open(dir string) (*Vault, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, &Error{"open", dir, nil, err}
+	}
+	if !fi.IsDir() {
+		return nil, &Error{"open", dir, nil, errors.New("not a directory")}
+	}
+
+	layout, err := readLayout(dir)
+	if err != nil {
+		return nil, &Error{"open", dir, nil, err}
+	}
+
+	home := GnuPGHome()
+	return &Vault{
+		Dir:            dir,
+		Layout:         layout,
+		SecRingPath:    filepath.Join(home, "secring.gpg"),
+		PubRingPath:    PublicKeyring(home),
+		PrivateKeysDir: filepath.Join(home, "private-keys-v1.d"),
+	}, nil
+}
+
+func (v *Vault) logf(format string, args ...interface{}) {
+	if v.Logf != nil {
+		v.Logf(format, args...)
+	}
+}